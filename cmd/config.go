@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const envPrefix = "PARTYDL"
+
+var (
+	configFile string
+	profile    string
+)
+
+func addConfigFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&configFile, "config", "", "", "Config file (default ~/.config/party-dl/config.yaml)")
+	cmd.PersistentFlags().StringVarP(&profile, "profile", "", "", "Named profile of the config file to apply on top of its top-level settings")
+}
+
+// loadConfig fills the flags of cmd that weren't given on the command line
+// from PARTYDL_* environment variables, the selected profile and the config
+// file, in this order of precedence. Config keys and env vars are named after
+// the flags, e.g. base-location and PARTYDL_BASE_LOCATION.
+func loadConfig(cmd *cobra.Command) error {
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+
+	path := configFile
+	if path == "" {
+		path = os.Getenv(envPrefix + "_CONFIG")
+	}
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile()
+	}
+	if path != "" {
+		viper.SetConfigFile(path)
+		if err := viper.ReadInConfig(); err != nil {
+			if explicit || !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to read config %s: %w", path, err)
+			}
+		}
+	}
+
+	// The profile can also be picked by PARTYDL_PROFILE or the config's
+	// top-level profile key.
+	if profile == "" {
+		profile = viper.GetString("profile")
+	}
+	if profile != "" {
+		if !viper.IsSet("profiles." + profile) {
+			return usageErrorf("profile %q not found in config %s", profile, path)
+		}
+		if err := viper.MergeConfigMap(viper.GetStringMap("profiles." + profile)); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || !viper.IsSet(flag.Name) {
+			return
+		}
+		if setErr := setFlag(cmd.Flags(), flag, viper.Get(flag.Name)); setErr != nil {
+			err = usageErrorf("invalid value for %s in config or environment: %v", flag.Name, setErr)
+		}
+	})
+	return err
+}
+
+// defaultConfigFile returns ~/.config/party-dl/config.yaml, or the same file
+// under $XDG_CONFIG_HOME.
+func defaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "party-dl", "config.yaml")
+}
+
+// setFlag sets flag to a value read by viper: a scalar, a list for slice
+// flags or a map for key=value flags.
+func setFlag(flags *pflag.FlagSet, flag *pflag.Flag, value any) error {
+	switch value := value.(type) {
+	case []any:
+		values := make([]string, len(value))
+		for i, v := range value {
+			values[i] = fmt.Sprint(v)
+		}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			if err := slice.Replace(values); err != nil {
+				return err
+			}
+			flag.Changed = true
+			return nil
+		}
+		return flags.Set(flag.Name, strings.Join(values, ","))
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := flags.Set(flag.Name, fmt.Sprintf("%s=%v", key, value[key])); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return flags.Set(flag.Name, fmt.Sprint(value))
+	}
+}
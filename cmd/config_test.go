@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const testConfig = `
+base-location: /data/party-dl
+threads: 4
+stash-path-map:
+  - /mnt/a=/a
+host-rate-limit:
+  kemono.su: 0.5
+
+profiles:
+  nas:
+    base-location: /mnt/nas/party-dl
+    stash-path-map:
+      - /mnt/nas=/data
+      - /mnt/b=/b
+  fast:
+    threads: 16
+`
+
+// configValues are the flags of the test command.
+type configValues struct {
+	BaseLocation  string
+	Threads       int
+	Stash         bool
+	PathMap       []string
+	HostRateLimit map[string]string
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		profile string
+		env     map[string]string
+		args    []string
+		want    configValues
+	}{
+		{
+			name:   "config file",
+			config: testConfig,
+			want: configValues{
+				BaseLocation:  "/data/party-dl",
+				Threads:       4,
+				PathMap:       []string{"/mnt/a=/a"},
+				HostRateLimit: map[string]string{"kemono.su": "0.5"},
+			},
+		},
+		{
+			name:    "profile on top of the config file",
+			config:  testConfig,
+			profile: "nas",
+			want: configValues{
+				BaseLocation:  "/mnt/nas/party-dl",
+				Threads:       4,
+				PathMap:       []string{"/mnt/nas=/data", "/mnt/b=/b"},
+				HostRateLimit: map[string]string{"kemono.su": "0.5"},
+			},
+		},
+		{
+			name:   "profile from the environment",
+			config: testConfig,
+			env:    map[string]string{"PARTYDL_PROFILE": "fast"},
+			want: configValues{
+				BaseLocation:  "/data/party-dl",
+				Threads:       16,
+				PathMap:       []string{"/mnt/a=/a"},
+				HostRateLimit: map[string]string{"kemono.su": "0.5"},
+			},
+		},
+		{
+			name:   "profile from the config file",
+			config: "profile: fast\n" + testConfig,
+			want: configValues{
+				BaseLocation:  "/data/party-dl",
+				Threads:       16,
+				PathMap:       []string{"/mnt/a=/a"},
+				HostRateLimit: map[string]string{"kemono.su": "0.5"},
+			},
+		},
+		{
+			name:    "environment over profile",
+			config:  testConfig,
+			profile: "nas",
+			env:     map[string]string{"PARTYDL_BASE_LOCATION": "/tmp/out", "PARTYDL_STASH": "true"},
+			want: configValues{
+				BaseLocation:  "/tmp/out",
+				Threads:       4,
+				Stash:         true,
+				PathMap:       []string{"/mnt/nas=/data", "/mnt/b=/b"},
+				HostRateLimit: map[string]string{"kemono.su": "0.5"},
+			},
+		},
+		{
+			name:    "command line over environment",
+			config:  testConfig,
+			profile: "nas",
+			env:     map[string]string{"PARTYDL_BASE_LOCATION": "/tmp/out", "PARTYDL_THREADS": "8"},
+			args:    []string{"--base-location", "./out", "--stash-path-map", "/x=/y"},
+			want: configValues{
+				BaseLocation:  "./out",
+				Threads:       8,
+				PathMap:       []string{"/x=/y"},
+				HostRateLimit: map[string]string{"kemono.su": "0.5"},
+			},
+		},
+		{
+			name: "no config file",
+			env:  map[string]string{"PARTYDL_THREADS": "2"},
+			want: configValues{Threads: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cmd, values := newConfigTestCommand(t, tt.config, tt.profile)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadConfig(cmd); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*values, tt.want) {
+				t.Errorf("loadConfig() set %+v, want %+v", *values, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		profile   string
+		env       map[string]string
+		wantUsage bool
+	}{
+		{name: "unknown profile", config: testConfig, profile: "missing", wantUsage: true},
+		{name: "invalid value", config: "threads: many\n", wantUsage: true},
+		{name: "invalid env value", env: map[string]string{"PARTYDL_THREADS": "many"}, wantUsage: true},
+		{name: "invalid yaml", config: "threads: [\n"},
+		{name: "missing explicit config", env: map[string]string{"PARTYDL_CONFIG": "/nonexistent/config.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cmd, _ := newConfigTestCommand(t, tt.config, tt.profile)
+			err := loadConfig(cmd)
+			if err == nil {
+				t.Fatal("loadConfig() succeeded, want an error")
+			}
+			if got := ExitCode(err) == ExitUsage; got != tt.wantUsage {
+				t.Errorf("loadConfig() = %v, usage error %v, want %v", err, got, tt.wantUsage)
+			}
+		})
+	}
+}
+
+// newConfigTestCommand returns a command with a few flags of every kind and
+// points loadConfig at config, or at a missing default config file if config
+// is empty. The global viper and flag state is restored by the test cleanup.
+func newConfigTestCommand(t *testing.T, config, profileName string) (*cobra.Command, *configValues) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	oldConfigFile, oldProfile := configFile, profile
+	t.Cleanup(func() { configFile, profile = oldConfigFile, oldProfile })
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	configFile = ""
+	if config != "" {
+		configFile = filepath.Join(dir, "config.yaml")
+		if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	profile = profileName
+
+	values := &configValues{}
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringVarP(&values.BaseLocation, "base-location", "", "", "")
+	cmd.Flags().IntVarP(&values.Threads, "threads", "", 0, "")
+	cmd.Flags().BoolVarP(&values.Stash, "stash", "", false, "")
+	cmd.Flags().StringArrayVarP(&values.PathMap, "stash-path-map", "", nil, "")
+	cmd.Flags().StringToStringVarP(&values.HostRateLimit, "host-rate-limit", "", nil, "")
+	return cmd, values
+}
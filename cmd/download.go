@@ -1,23 +1,27 @@
 package cmd
 
 import (
+	"context"
 	"party-dl/internal/coomer"
 	"party-dl/internal/downloader"
 	"party-dl/internal/metadata"
+	"party-dl/internal/ratelimit"
 	"path"
+	"time"
 
-	"github.com/alitto/pond"
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 	"party-dl/internal/utils"
-	"sync"
 )
 
 var (
-	failedMutex    sync.Mutex
 	baseLocation   string
 	numThreads     int
 	defaultThreads = 3
+	cookiesFile    string
+	sessionCookie  string
+	dryRun         bool
+	jsonOutput     bool
 )
 
 func downloadCmd() *cobra.Command {
@@ -26,131 +30,183 @@ func downloadCmd() *cobra.Command {
 		Short:   "download a creator's page",
 		Example: "party-dl download {url}",
 		Aliases: []string{"d", "download"},
-		Args:    cobra.ExactArgs(1),
+		Args:    usageArgs(cobra.ExactArgs(1)),
 		RunE:    download,
 	}
 	cmd.Flags().StringVarP(&baseLocation, "base-location", "b", "./", "Base download location")
 	cmd.Flags().IntVarP(&numThreads, "threads", "t", defaultThreads, "Number of download threads")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "Show what would be downloaded and its estimated size without downloading")
+	addSessionFlags(cmd)
+	addRateLimitFlags(cmd)
+	addFilterFlags(cmd)
+	addReportFlags(cmd)
+	addStashSyncFlags(cmd)
 	return cmd
 }
 
 func download(cmd *cobra.Command, args []string) error {
+	startReport("download")
 	url := args[0]
 	if !utils.IsURlSupported(url) {
-		log.Errorf("%s is not a supported url", url)
-		return nil
+		return usageErrorf("%s is not a supported url", url)
+	}
+	if err := checkThreads(); err != nil {
+		return err
+	}
+	if err := parseFilterFlags(); err != nil {
+		return err
+	}
+	if err := startStashSync(); err != nil {
+		return err
 	}
-	log.Infof("Downloading %s", url)
 
-	coomerManager, err := coomer.New()
+	ctx := cmd.Context()
+
+	coomerManager, err := newCoomerManager(ctx, url)
 	if err != nil {
-		log.Error(err)
-		return nil
+		return err
 	}
 
-	info, err := coomerManager.CreatorInfo(url)
+	stopProgress := startProgress()
+	defer stopProgress()
+
+	stats, err := downloadCreator(ctx, coomerManager, url)
 	if err != nil {
-		log.Error(err)
-		return nil
+		return err
 	}
 
-	log.Info("Scraping creator", "name", info.Name, "service", info.Service, "page", info.ServiceLink, "posts", info.Posts)
+	log.Infof("Done.")
+
+	return stats.err()
+}
 
-	posts, err := scrapePosts(coomerManager, url)
+func newCoomerManager(ctx context.Context, siteURL string) (*coomer.Manager, error) {
+	if err := initRateLimits(ctx); err != nil {
+		return nil, err
+	}
+	coomerManager, err := coomer.New()
 	if err != nil {
-		log.Error(err)
-		return nil
+		return nil, err
+	}
+	coomerManager.Client.Transport = ratelimit.NewTransport(pageLimiter)
+	if cookiesFile != "" {
+		if err := coomerManager.LoadCookiesFile(cookiesFile); err != nil {
+			return nil, err
+		}
 	}
+	if sessionCookie != "" {
+		coomerManager.SetSession(sessionCookie, siteURL)
+	}
+	return coomerManager, nil
+}
 
-	basePath := path.Join(baseLocation, info.Name)
+// checkThreads rejects a --threads value no worker pool can run with.
+func checkThreads() error {
+	if numThreads < 1 {
+		return usageErrorf("--threads must be at least 1, got %d", numThreads)
+	}
+	return nil
+}
 
-	downloadManager := downloader.NewDownloader(basePath, metadata.CreatorInfo{
-		Name:     info.Name,
-		Service:  info.Service,
-		PageLink: info.ServiceLink,
-	})
+func addSessionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&cookiesFile, "cookies", "", "", "Path to a Netscape cookies.txt file")
+	cmd.Flags().StringVarP(&sessionCookie, "session", "", "", "Value of the site's session cookie")
+}
 
-	failedPosts := downloadPosts(coomerManager, downloadManager, posts)
+// downloadCreator downloads a creator's posts. Failed posts and files are
+// only counted in the returned stats, the error is reserved for failures that
+// stop the whole creator, including an interrupt.
+func downloadCreator(ctx context.Context, coomerManager *coomer.Manager, url string) (pipelineStats, error) {
+	log.Info("Downloading creator", "url", url)
+	started := time.Now()
 
-	retryFailedPosts(downloadManager, failedPosts)
+	info, err := coomerManager.CreatorInfo(ctx, url)
+	if err != nil {
+		report.addError("", url, started, err)
+		return pipelineStats{}, interrupted(ctx, err)
+	}
 
-	log.Infof("Done.")
+	log.Info("Scraping creator", "creator", info.Name, "service", info.Service, "page", info.ServiceLink, "posts", info.Posts)
 
-	return nil
-}
+	downloadManager := newCreatorDownloader(info)
 
-func scrapePosts(coomerManager *coomer.Manager, url string) ([]coomer.Post, error) {
-	scrapeIndex := 0
-	var posts []coomer.Post
-	for {
-		log.Infof("Scraping page %v", scrapeIndex+1)
-		pagePosts, done, err := coomerManager.ScrapePage(url, scrapeIndex)
+	posts := newPipeline(ctx, coomerManager, downloadManager)
+	if dryRun {
+		posts.plan = newDryRunPlan(info.Name)
+	} else {
+		creatorLock, err := lockCreator(ctx, downloadManager.BaseDir)
 		if err != nil {
-			return nil, err
+			report.addError(info.Name, url, started, err)
+			return pipelineStats{}, interrupted(ctx, err)
 		}
-		if done {
-			log.Infof("Page %v doesn't exists. Finished scraping.", scrapeIndex+1)
-			break
+		defer unlockCreator(creatorLock)
+	}
+	scrapeErr := scrapePosts(ctx, posts.logger, coomerManager, url, info.Posts, posts.SubmitPost)
+	posts.Wait()
+	if posts.plan != nil {
+		report.addDownload(info, url, "", started, posts, downloadManager)
+		if err := posts.plan.print(); err != nil {
+			return posts.Stats(), err
 		}
-		posts = append(posts, pagePosts...)
-		scrapeIndex++
+		return posts.Stats(), interrupted(ctx, scrapeErr)
 	}
-	log.Infof("Total scraped posts: %v", len(posts))
-	return posts, nil
-}
 
-func downloadPosts(coomerManager *coomer.Manager, downloadManager *downloader.Downloader, posts []coomer.Post) []coomer.PostContent {
-	pool := pond.New(numThreads, len(posts))
-	var failedPosts []coomer.PostContent
-
-	for _, post := range posts {
-		postCopy := post                   // Create a copy of post inside the loop
-		coomerManagerCopy := coomerManager // Create a copy of coomerManager inside the loop
-		pool.Submit(func() {
-			postContent, err := coomerManagerCopy.GetPostContent("https://coomer.su" + postCopy.URL)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			for _, url := range postContent.DownloadURLS {
-				downloadedPath, exists, err := downloadManager.DownloadURL(url, postContent.Description, postContent.Published)
-				if err != nil {
-					log.Error(err)
-					failedMutex.Lock()
-					failedPosts = append(failedPosts, coomer.PostContent{Description: postContent.Description, DownloadURLS: []string{url}, Published: postContent.Published})
-					failedMutex.Unlock()
-					continue
-				}
-				if exists {
-					log.Infof("%s has already been downloaded", url)
-				} else {
-					log.Infof("Downloaded %s to %s", url, downloadedPath)
-				}
-			}
-		})
-	}
-
-	pool.StopAndWait()
-	return failedPosts
+	posts.RetryFailed()
+	posts.LogSummary()
+	syncErr := posts.SyncStash()
+	report.addDownload(info, url, "", started, posts, downloadManager)
+	if syncErr != nil {
+		return posts.Stats(), syncErr
+	}
+
+	return posts.Stats(), interrupted(ctx, scrapeErr)
 }
 
-func retryFailedPosts(downloadManager *downloader.Downloader, failedPosts []coomer.PostContent) {
-	log.Infof("Retrying %v failed posts", len(failedPosts))
-	for _, post := range failedPosts {
-		for _, url := range post.DownloadURLS {
-			downloadedPath, exists, err := downloadManager.DownloadURL(url, post.Description, post.Published)
-			if err != nil {
-				log.Error(err)
-				failedMutex.Lock()
-				failedPosts = append(failedPosts, coomer.PostContent{Description: post.Description, DownloadURLS: []string{url}})
-				failedMutex.Unlock()
-				continue
-			}
-			if exists {
-				log.Infof("%s has already been downloaded", url)
-			} else {
-				log.Infof("Downloaded %s to %s", url, downloadedPath)
-			}
-		}
+// downloadSinglePost downloads one post into its creator's directory.
+func downloadSinglePost(ctx context.Context, coomerManager *coomer.Manager, creatorURL, postURL string) (pipelineStats, error) {
+	log.Info("Downloading post", "post", postURL)
+	started := time.Now()
+
+	info, err := coomerManager.CreatorInfo(ctx, creatorURL)
+	if err != nil {
+		report.addError("", creatorURL, started, err)
+		return pipelineStats{}, interrupted(ctx, err)
 	}
+
+	downloadManager := newCreatorDownloader(info)
+
+	creatorLock, err := lockCreator(ctx, downloadManager.BaseDir)
+	if err != nil {
+		report.addError(info.Name, creatorURL, started, err)
+		return pipelineStats{}, interrupted(ctx, err)
+	}
+	defer unlockCreator(creatorLock)
+
+	posts := newPipeline(ctx, coomerManager, downloadManager)
+	posts.SubmitPost(coomer.Post{URL: postURL})
+	posts.Wait()
+
+	posts.RetryFailed()
+	posts.LogSummary()
+	syncErr := posts.SyncStash()
+	report.addDownload(info, creatorURL, postURL, started, posts, downloadManager)
+	if syncErr != nil {
+		return posts.Stats(), syncErr
+	}
+
+	return posts.Stats(), interrupted(ctx, nil)
+}
+
+func newCreatorDownloader(info *coomer.CreatorInfo) *downloader.Downloader {
+	basePath := path.Join(baseLocation, info.Name)
+
+	downloadManager := downloader.NewDownloader(basePath, metadata.CreatorInfo{
+		Name:     info.Name,
+		Service:  info.Service,
+		PageLink: info.ServiceLink,
+	})
+	downloadManager.Client = downloadClient
+	downloadManager.Bandwidth = bandwidth
+	downloadManager.Progress = tracker
+	return downloadManager
 }
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"party-dl/internal/downloader"
+	"party-dl/internal/progress"
+	"sync"
+)
+
+type dryRunFile struct {
+	URL       string `json:"url"`
+	Post      string `json:"post"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+}
+
+type dryRunTotals struct {
+	Files        int   `json:"files"`
+	Bytes        int64 `json:"bytes"`
+	ImageBytes   int64 `json:"imageBytes"`
+	VideoBytes   int64 `json:"videoBytes"`
+	OtherBytes   int64 `json:"otherBytes"`
+	UnknownSizes int   `json:"unknownSizes"`
+}
+
+// dryRunPlan collects what a download would do without downloading anything.
+type dryRunPlan struct {
+	mu       sync.Mutex
+	Creator  string       `json:"creator"`
+	Download []dryRunFile `json:"download"`
+	Skip     []dryRunFile `json:"skip"`
+	Failed   []dryRunFile `json:"failed"`
+	Totals   dryRunTotals `json:"totals"`
+}
+
+func newDryRunPlan(creator string) *dryRunPlan {
+	return &dryRunPlan{
+		Creator:  creator,
+		Download: []dryRunFile{},
+		Skip:     []dryRunFile{},
+		Failed:   []dryRunFile{},
+	}
+}
+
+func (p *dryRunPlan) add(job fileJob, probe downloader.Probe, err error) {
+	file := dryRunFile{URL: job.URL, Post: job.Post.URL, MediaType: probe.MediaType, Size: probe.Size}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err != nil:
+		p.Failed = append(p.Failed, file)
+	case probe.Exists:
+		p.Skip = append(p.Skip, file)
+	default:
+		p.Download = append(p.Download, file)
+		p.Totals.Files++
+		if probe.Size < 0 {
+			p.Totals.UnknownSizes++
+			return
+		}
+		p.Totals.Bytes += probe.Size
+		switch probe.MediaType {
+		case "images":
+			p.Totals.ImageBytes += probe.Size
+		case "videos":
+			p.Totals.VideoBytes += probe.Size
+		default:
+			p.Totals.OtherBytes += probe.Size
+		}
+	}
+}
+
+func (p *dryRunPlan) print() error {
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	}
+
+	for _, file := range p.Download {
+		size := "unknown size"
+		if file.Size >= 0 {
+			size = progress.FormatBytes(file.Size)
+		}
+		fmt.Printf("download %s (%s, %s)\n", file.URL, file.MediaType, size)
+	}
+	for _, file := range p.Skip {
+		fmt.Printf("skip     %s (already downloaded)\n", file.URL)
+	}
+	for _, file := range p.Failed {
+		fmt.Printf("failed   %s\n", file.URL)
+	}
+	fmt.Printf("\n%s: %d files to download, %d already downloaded, %d failed\n", p.Creator, p.Totals.Files, len(p.Skip), len(p.Failed))
+	fmt.Printf("Estimated size: %s (images %s, videos %s, other %s)",
+		progress.FormatBytes(p.Totals.Bytes), progress.FormatBytes(p.Totals.ImageBytes),
+		progress.FormatBytes(p.Totals.VideoBytes), progress.FormatBytes(p.Totals.OtherBytes))
+	if p.Totals.UnknownSizes > 0 {
+		fmt.Printf(", %d files of unknown size", p.Totals.UnknownSizes)
+	}
+	fmt.Println()
+	return nil
+}
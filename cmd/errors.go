@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of party-dl.
+const (
+	ExitOK          = 0
+	ExitFailure     = 1
+	ExitUsage       = 2
+	ExitPartial     = 3
+	ExitInterrupted = 130
+)
+
+// errInterrupted is returned by commands stopped by Ctrl-C or SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+// usageError is an error in the command line, such as an invalid flag value.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+
+func (e *usageError) Unwrap() error { return e.err }
+
+// usage marks err as a usage error, nil stays nil.
+func usage(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &usageError{err: err}
+}
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{err: fmt.Errorf(format, args...)}
+}
+
+// usageArgs marks the errors of an argument validator as usage errors.
+func usageArgs(args func(*cobra.Command, []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, a []string) error {
+		return usage(args(cmd, a))
+	}
+}
+
+// partialError reports a run in which some of the items failed. The failures
+// themselves have already been logged.
+type partialError struct {
+	Failed int
+	Total  int
+	What   string
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("%d of %d %s failed", e.Failed, e.Total, e.What)
+}
+
+// interrupted replaces err with errInterrupted once ctx is cancelled, as the
+// cancellation usually surfaces as an unrelated request error.
+func interrupted(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	return err
+}
+
+// ExitCode returns the exit code for an error returned by Execute: a usage
+// error, a run in which only some items failed, an interrupted run, or any
+// other failure.
+func ExitCode(err error) int {
+	var usageErr *usageError
+	var partialErr *partialError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, errInterrupted):
+		return ExitInterrupted
+	case errors.As(err, &usageErr):
+		return ExitUsage
+	case errors.As(err, &partialErr) && partialErr.Failed < partialErr.Total:
+		return ExitPartial
+	default:
+		return ExitFailure
+	}
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, ExitOK},
+		{"failure", errors.New("boom"), ExitFailure},
+		{"usage", usageErrorf("invalid --only value %q", "audio"), ExitUsage},
+		{"wrapped usage", fmt.Errorf("download: %w", usage(errors.New("bad flag"))), ExitUsage},
+		{"partial", &partialError{Failed: 1, Total: 3, What: "downloads"}, ExitPartial},
+		{"all failed", &partialError{Failed: 3, Total: 3, What: "downloads"}, ExitFailure},
+		{"interrupted", errInterrupted, ExitInterrupted},
+		{"wrapped interrupted", fmt.Errorf("creator alice: %w", errInterrupted), ExitInterrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageNil(t *testing.T) {
+	if err := usage(nil); err != nil {
+		t.Errorf("usage(nil) = %v, want nil", err)
+	}
+}
+
+func TestInterrupted(t *testing.T) {
+	err := errors.New("context canceled")
+	if got := interrupted(context.Background(), err); got != err {
+		t.Errorf("interrupted() with a live context = %v, want %v", got, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := interrupted(ctx, err); got != errInterrupted {
+		t.Errorf("interrupted() with a cancelled context = %v, want errInterrupted", got)
+	}
+}
+
+func TestCheckThreads(t *testing.T) {
+	defer func(threads int) { numThreads = threads }(numThreads)
+	for _, threads := range []int{-1, 0} {
+		numThreads = threads
+		if got := ExitCode(checkThreads()); got != ExitUsage {
+			t.Errorf("checkThreads() with %d threads exits with %d, want %d", threads, got, ExitUsage)
+		}
+	}
+	numThreads = 1
+	if err := checkThreads(); err != nil {
+		t.Errorf("checkThreads() with 1 thread = %v", err)
+	}
+}
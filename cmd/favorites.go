@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"errors"
+	"party-dl/internal/coomer"
+	stash2 "party-dl/internal/stash"
+	"party-dl/internal/subscriptions"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	favoritesSite     string
+	subscriptionsFile string
+	downloadFavorites bool
+)
+
+func favoritesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "favorites",
+		Short: "work with the account's favorites",
+	}
+	cmd.AddCommand(favoritesImportCmd())
+	return cmd
+}
+
+func favoritesImportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "import --session {SESSION_COOKIE}",
+		Short:   "import favorited creators and download favorited posts",
+		Example: "party-dl favorites import --site https://kemono.su --session {SESSION_COOKIE}",
+		Args:    usageArgs(cobra.NoArgs),
+		RunE:    importFavorites,
+	}
+	cmd.Flags().StringVarP(&favoritesSite, "site", "", "https://coomer.su", "Site to read the favorites from")
+	cmd.Flags().StringVarP(&subscriptionsFile, "subscriptions", "", "subscriptions.json", "Subscription list the favorited creators are added to")
+	cmd.Flags().BoolVarP(&downloadFavorites, "download", "", false, "Download favorited creators instead of subscribing to them")
+	cmd.Flags().StringVarP(&baseLocation, "base-location", "b", "./", "Base download location")
+	cmd.Flags().IntVarP(&numThreads, "threads", "t", defaultThreads, "Number of download threads")
+	addSessionFlags(cmd)
+	addRateLimitFlags(cmd)
+	addFilterFlags(cmd)
+	addReportFlags(cmd)
+	addStashSyncFlags(cmd)
+	return cmd
+}
+
+func importFavorites(cmd *cobra.Command, args []string) error {
+	startReport("favorites import")
+	if err := checkThreads(); err != nil {
+		return err
+	}
+	if err := parseFilterFlags(); err != nil {
+		return err
+	}
+	if err := startStashSync(); err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+
+	coomerManager, err := newCoomerManager(ctx, favoritesSite)
+	if err != nil {
+		return err
+	}
+
+	creators, err := coomerManager.FavoriteCreators(ctx, favoritesSite)
+	if err != nil {
+		return interrupted(ctx, err)
+	}
+	log.Infof("Found %d favorited creators", len(creators))
+
+	posts, err := coomerManager.FavoritePosts(ctx, favoritesSite)
+	if err != nil {
+		return interrupted(ctx, err)
+	}
+	log.Infof("Found %d favorited posts", len(posts))
+
+	stopProgress := startProgress()
+	defer stopProgress()
+
+	// A creator or post that couldn't be downloaded at all counts as one
+	// failed download.
+	var total pipelineStats
+	if downloadFavorites {
+		for _, creator := range creators {
+			stats, err := downloadCreator(ctx, coomerManager, creator.URL(favoritesSite))
+			if fatal(err) {
+				return err
+			} else if err != nil {
+				log.Error(err)
+				stats.FailedPosts++
+			}
+			total = total.add(stats)
+		}
+	} else if err := subscribeCreators(creators); err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		stats, err := downloadSinglePost(ctx, coomerManager, post.CreatorURL(favoritesSite), post.URL(favoritesSite))
+		if fatal(err) {
+			return err
+		} else if err != nil {
+			log.Error(err)
+			stats.FailedPosts++
+		}
+		total = total.add(stats)
+	}
+
+	log.Infof("Done.")
+
+	return total.err()
+}
+
+// fatal reports whether err stops the whole import, because it was
+// interrupted or every other creator would fail the same way.
+func fatal(err error) bool {
+	var authErr *stash2.AuthError
+	return errors.Is(err, errInterrupted) || errors.As(err, &authErr)
+}
+
+func subscribeCreators(creators []coomer.FavoriteCreator) error {
+	list, err := subscriptions.Read(subscriptionsFile)
+	if err != nil {
+		return err
+	}
+
+	for _, creator := range creators {
+		added := list.Add(subscriptions.Subscription{
+			URL:     creator.URL(favoritesSite),
+			Name:    creator.Name,
+			Service: creator.Service,
+		})
+		if added {
+			log.Info("Subscribed to creator", "creator", creator.Name, "service", creator.Service)
+		}
+	}
+
+	return subscriptions.Write(subscriptionsFile, list)
+}
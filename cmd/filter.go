@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"party-dl/internal/filter"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	onlyMediaType string
+	includeExt    []string
+	excludeExt    []string
+	minSize       string
+	maxSize       string
+	matchPosts    []string
+	excludePosts  []string
+	postIDs       []string
+	skipPostIDs   []string
+
+	fileFilter filter.Files
+	postFilter filter.Posts
+)
+
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&onlyMediaType, "only", "", "", "Only download images, videos or attachments")
+	cmd.Flags().StringSliceVarP(&includeExt, "include-ext", "", nil, "Only download files with these extensions, e.g. jpg,png")
+	cmd.Flags().StringSliceVarP(&excludeExt, "exclude-ext", "", nil, "Don't download files with these extensions, e.g. gif")
+	cmd.Flags().StringVarP(&minSize, "min-size", "", "", "Skip files smaller than this, e.g. 100K")
+	cmd.Flags().StringVarP(&maxSize, "max-size", "", "", "Skip files larger than this, e.g. 2G")
+	cmd.Flags().StringArrayVarP(&matchPosts, "match", "", nil, "Only download posts whose title or description matches this regex, repeatable")
+	cmd.Flags().StringArrayVarP(&excludePosts, "exclude", "", nil, "Skip posts whose title or description matches this regex, repeatable")
+	cmd.Flags().StringSliceVarP(&postIDs, "post-id", "", nil, "Only download posts with these IDs")
+	cmd.Flags().StringSliceVarP(&skipPostIDs, "skip-post-id", "", nil, "Skip posts with these IDs")
+}
+
+func parseFilterFlags() error {
+	var err error
+	fileFilter, err = filter.NewFiles(onlyMediaType, includeExt, excludeExt, minSize, maxSize)
+	if err != nil {
+		return usage(err)
+	}
+	postFilter, err = filter.NewPosts(matchPosts, excludePosts, postIDs, skipPostIDs)
+	return usage(err)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"party-dl/internal/lock"
+
+	"github.com/charmbracelet/log"
+)
+
+var (
+	waitLock bool
+	// runCommand is the command line recorded in the locks of this run.
+	runCommand string
+)
+
+// lockCreator locks a creator's directory so no other run writes to it at the
+// same time. Without --wait-lock a directory locked by another run fails
+// fast with a *lock.LockedError.
+func lockCreator(ctx context.Context, dir string) (*lock.Lock, error) {
+	l, err := lock.Acquire(ctx, dir, runCommand, false)
+	var locked *lock.LockedError
+	if !waitLock || !errors.As(err, &locked) {
+		return l, err
+	}
+	log.Warn("Directory is locked by another run, waiting for it to be released", "dir", dir, "pid", locked.Holder.PID, "host", locked.Holder.Host)
+	return lock.Acquire(ctx, dir, runCommand, true)
+}
+
+// unlockCreator releases a lock taken by lockCreator.
+func unlockCreator(l *lock.Lock) {
+	if err := l.Release(); err != nil {
+		log.Warnf("Failed to release lock: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logFormat string
+	logLevel  string
+	quiet     bool
+	logFile   string
+
+	// logOutput is where log lines go when they aren't routed through the
+	// progress view.
+	logOutput io.Writer = os.Stderr
+	// closeLogFile closes the --log-file, if any.
+	closeLogFile = func() {}
+)
+
+func addLogFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&logFormat, "log-format", "", "text", "Log format: text, json or logfmt")
+	cmd.PersistentFlags().StringVarP(&logLevel, "log-level", "", "info", "Log level: debug, info, warn, error or fatal")
+	cmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only log errors and don't show progress")
+	cmd.PersistentFlags().StringVarP(&logFile, "log-file", "", "", "Append log lines to this file instead of stderr")
+}
+
+// setupLogging configures the default logger from the log flags.
+func setupLogging() error {
+	switch strings.ToLower(logFormat) {
+	case "text":
+		log.SetFormatter(log.TextFormatter)
+	case "json":
+		log.SetFormatter(log.JSONFormatter)
+		log.SetTimeFormat(time.RFC3339)
+	case "logfmt":
+		log.SetFormatter(log.LogfmtFormatter)
+		log.SetTimeFormat(time.RFC3339)
+	default:
+		return usageErrorf("invalid log format %q, expected text, json or logfmt", logFormat)
+	}
+
+	level, err := log.ParseLevel(logLevel)
+	if err != nil {
+		return usageErrorf("invalid log level %q, expected debug, info, warn, error or fatal", logLevel)
+	}
+	if quiet {
+		level = log.ErrorLevel
+	}
+	log.SetLevel(level)
+
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		logOutput = file
+		closeLogFile = func() { file.Close() }
+	}
+	log.SetOutput(logOutput)
+	return nil
+}
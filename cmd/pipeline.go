@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"party-dl/internal/coomer"
+	"party-dl/internal/downloader"
+	"party-dl/internal/metadata"
+	stash2 "party-dl/internal/stash"
+	"sync"
+
+	"github.com/alitto/pond"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	postQueueSize = 100
+	fileQueueSize = 200
+)
+
+// fileJob is a single file of a resolved post.
+type fileJob struct {
+	URL  string
+	Post *coomer.PostContent
+}
+
+type fileResult int
+
+const (
+	fileDownloaded fileResult = iota
+	fileExists
+	fileFiltered
+)
+
+// pipelineStats counts what happened to the posts and files of a pipeline.
+type pipelineStats struct {
+	Scraped     int
+	Posts       int
+	FailedPosts int
+	Downloaded  int
+	Existing    int
+	Filtered    int
+	Failed      int
+	Cancelled   int
+	// Stash sync counts.
+	StashUpdated int
+	StashFailed  int
+}
+
+func (s pipelineStats) add(other pipelineStats) pipelineStats {
+	s.Scraped += other.Scraped
+	s.Posts += other.Posts
+	s.FailedPosts += other.FailedPosts
+	s.Downloaded += other.Downloaded
+	s.Existing += other.Existing
+	s.Filtered += other.Filtered
+	s.Failed += other.Failed
+	s.Cancelled += other.Cancelled
+	s.StashUpdated += other.StashUpdated
+	s.StashFailed += other.StashFailed
+	return s
+}
+
+// err returns a *partialError if any post, file or stash update failed.
+func (s pipelineStats) err() error {
+	failed := s.FailedPosts + s.Failed
+	if failed+s.StashFailed == 0 {
+		return nil
+	}
+	if failed == 0 {
+		return &partialError{Failed: s.StashFailed, Total: s.StashFailed + s.StashUpdated, What: "stash updates"}
+	}
+	return &partialError{Failed: failed, Total: failed + s.Downloaded + s.Existing, What: "downloads"}
+}
+
+// failure is a post or file that couldn't be downloaded.
+type failure struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+// pipeline resolves posts and downloads their files while the creator is
+// still being scraped. Both stages have bounded queues, so a full download
+// queue holds up post resolving, which in turn holds up scraping.
+//
+// Once ctx is cancelled queued posts and files are dropped, and running
+// downloads stop and leave their part file to be resumed by the next run.
+type pipeline struct {
+	ctx             context.Context
+	coomerManager   *coomer.Manager
+	downloadManager *downloader.Downloader
+	// logger logs with the creator's name.
+	logger *log.Logger
+	posts  *pond.WorkerPool
+	files  *pond.WorkerPool
+	// plan, if set, makes the pipeline probe the files instead of downloading them.
+	plan *dryRunPlan
+
+	mu       sync.Mutex
+	failed   []fileJob
+	failures []failure
+	// downloaded are the URLs of the files downloaded by this run.
+	downloaded []string
+	stats      pipelineStats
+}
+
+func newPipeline(ctx context.Context, coomerManager *coomer.Manager, downloadManager *downloader.Downloader) *pipeline {
+	return &pipeline{
+		ctx:             ctx,
+		coomerManager:   coomerManager,
+		downloadManager: downloadManager,
+		logger:          log.With("creator", downloadManager.Creator.Name),
+		posts:           pond.New(numThreads, postQueueSize),
+		files:           pond.New(numThreads, fileQueueSize),
+	}
+}
+
+// SubmitPost queues a post to be resolved, blocking while the queue is full.
+func (p *pipeline) SubmitPost(post coomer.Post) {
+	if p.ctx.Err() != nil {
+		return
+	}
+	p.mu.Lock()
+	p.stats.Scraped++
+	p.mu.Unlock()
+	if !postFilter.MatchID(post.ID()) {
+		p.logger.Debug("Skipping post, filtered out by ID", "post", post.URL)
+		return
+	}
+	p.posts.Submit(func() {
+		p.resolvePost(post)
+	})
+}
+
+// Wait waits until every submitted post has been downloaded.
+func (p *pipeline) Wait() {
+	p.posts.StopAndWait()
+	p.files.StopAndWait()
+}
+
+// RetryFailed downloads the files that failed once more, one at a time. It
+// must be called after Wait.
+func (p *pipeline) RetryFailed() {
+	failed := p.failed
+	p.failed = nil
+	if len(failed) == 0 {
+		return
+	}
+
+	p.logger.Info("Retrying failed files", "files", len(failed))
+	for _, job := range failed {
+		if p.ctx.Err() != nil {
+			p.stats.Cancelled++
+			continue
+		}
+		result, err := p.downloadJob(job)
+		if err != nil {
+			if p.ctx.Err() != nil {
+				p.stats.Cancelled++
+				continue
+			}
+			p.logger.Error("Failed to download file", "post", job.Post.URL, "url", job.URL, "err", err)
+			p.failed = append(p.failed, job)
+			p.failures = append(p.failures, failure{URL: job.URL, Reason: err.Error()})
+			p.stats.Failed++
+			continue
+		}
+		p.count(result)
+	}
+}
+
+// Stats returns the counts of the pipeline.
+func (p *pipeline) Stats() pipelineStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Failures returns the posts and files that failed for good, an empty slice
+// rather than nil if none did, so reports always encode a list.
+func (p *pipeline) Failures() []failure {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]failure{}, p.failures...)
+}
+
+// Downloaded returns the URLs of the files the pipeline downloaded.
+func (p *pipeline) Downloaded() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.downloaded...)
+}
+
+// SyncStash adds the files the pipeline downloaded to stash when --stash is
+// set. It must be called after RetryFailed. Failures are counted in the
+// pipeline's stats, the error is reserved for an interrupt and for stash
+// rejecting the API key.
+func (p *pipeline) SyncStash() error {
+	if stashSync == nil || p.ctx.Err() != nil {
+		return nil
+	}
+	stats, err := syncCreator(p.ctx, stashSync, p.logger, p.downloadManager.BaseDir, p.Downloaded())
+	var authErr *stash2.AuthError
+	if errors.As(err, &authErr) {
+		return authErr
+	}
+	if err != nil && p.ctx.Err() == nil {
+		stats.fail(p.logger, p.downloadManager.BaseDir, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.StashUpdated += stats.Updated
+	p.stats.StashFailed += stats.Failed
+	p.failures = append(p.failures, stats.Failures...)
+	return interrupted(p.ctx, nil)
+}
+
+// LogSummary logs what the pipeline did.
+func (p *pipeline) LogSummary() {
+	stats := p.Stats()
+	p.logger.Info("Summary", "posts", stats.Posts, "downloaded", stats.Downloaded, "existing", stats.Existing,
+		"filtered", stats.Filtered, "failed", stats.Failed, "failed_posts", stats.FailedPosts)
+	if p.ctx.Err() != nil {
+		p.logger.Warnf("Interrupted, %d files were not downloaded. Partial downloads are resumed by the next run.", stats.Cancelled)
+	}
+}
+
+func (p *pipeline) resolvePost(post coomer.Post) {
+	if p.ctx.Err() != nil {
+		return
+	}
+	postContent, err := p.coomerManager.GetPostContent(p.ctx, post.URL)
+	if err != nil {
+		if p.ctx.Err() == nil {
+			p.logger.Error("Failed to get post", "post", post.URL, "err", err)
+			tracker.Failed()
+			p.mu.Lock()
+			p.failures = append(p.failures, failure{URL: post.URL, Reason: err.Error()})
+			p.stats.FailedPosts++
+			p.mu.Unlock()
+		}
+		return
+	}
+	p.mu.Lock()
+	p.stats.Posts++
+	p.mu.Unlock()
+	if !postFilter.MatchContent(postContent.Title, postContent.Description) {
+		p.logger.Info("Skipping post, filtered out by title or description", "post", post.URL)
+		return
+	}
+	var urls []string
+	seen := make(map[string]bool)
+	for _, url := range postContent.DownloadURLS {
+		// A post can link the same file more than once.
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		if fileFilter.MatchURL(url) {
+			urls = append(urls, url)
+		} else {
+			p.logger.Debug("Skipping file, filtered out", "post", post.URL, "url", url)
+			p.count(fileFiltered)
+		}
+	}
+	tracker.AddQueued(len(urls))
+	for _, url := range urls {
+		job := fileJob{URL: url, Post: postContent}
+		p.files.Submit(func() {
+			p.downloadFile(job)
+		})
+	}
+}
+
+func (p *pipeline) downloadFile(job fileJob) {
+	tracker.AddQueued(-1)
+	if p.ctx.Err() != nil {
+		p.mu.Lock()
+		p.stats.Cancelled++
+		p.mu.Unlock()
+		return
+	}
+	if p.plan != nil {
+		probe, err := p.downloadManager.ProbeURL(p.ctx, job.URL)
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return
+			}
+			p.logger.Error("Failed to probe file", "post", job.Post.URL, "url", job.URL, "err", err)
+			p.mu.Lock()
+			p.failures = append(p.failures, failure{URL: job.URL, Reason: err.Error()})
+			p.stats.Failed++
+			p.mu.Unlock()
+		}
+		if err == nil && !probe.Exists && !fileFilter.MatchSize(probe.Size) {
+			p.logger.Debug("Skipping file, size filtered out", "post", job.Post.URL, "url", job.URL, "size", probe.Size)
+			return
+		}
+		p.plan.add(job, probe, err)
+		return
+	}
+	result, err := p.downloadJob(job)
+	if err != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.ctx.Err() != nil {
+			p.stats.Cancelled++
+			return
+		}
+		p.logger.Warn("Failed to download file, will retry", "post", job.Post.URL, "url", job.URL, "err", err)
+		p.failed = append(p.failed, job)
+		return
+	}
+	p.count(result)
+}
+
+func (p *pipeline) count(result fileResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch result {
+	case fileDownloaded:
+		p.stats.Downloaded++
+	case fileExists:
+		p.stats.Existing++
+	case fileFiltered:
+		p.stats.Filtered++
+	}
+}
+
+func (p *pipeline) downloadJob(job fileJob) (fileResult, error) {
+	if fileFilter.NeedsSize() {
+		// ProbeURL only asks the server for the size of files that haven't
+		// been downloaded yet.
+		probe, err := p.downloadManager.ProbeURL(p.ctx, job.URL)
+		if err != nil {
+			return 0, err
+		}
+		if !probe.Exists && !fileFilter.MatchSize(probe.Size) {
+			p.logger.Info("Skipping file, size filtered out", "post", job.Post.URL, "url", job.URL, "size", probe.Size)
+			return fileFiltered, nil
+		}
+	}
+	downloadedPath, exists, err := p.downloadManager.DownloadURL(p.ctx, job.URL, metadata.Post{
+		URL:         job.Post.URL,
+		ID:          job.Post.ID,
+		Title:       job.Post.Title,
+		Description: job.Post.Description,
+		Published:   job.Post.Published,
+		Tags:        job.Post.Tags,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		p.logger.Info("File has already been downloaded", "post", job.Post.URL, "url", job.URL)
+		return fileExists, nil
+	}
+	p.logger.Info("Downloaded file", "post", job.Post.URL, "url", job.URL, "path", downloadedPath)
+	p.mu.Lock()
+	p.downloaded = append(p.downloaded, job.URL)
+	p.mu.Unlock()
+	return fileDownloaded, nil
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"os"
+	"party-dl/internal/progress"
+
+	"github.com/charmbracelet/log"
+	"github.com/muesli/termenv"
+)
+
+// tracker shows the download progress of the running command.
+var tracker *progress.Tracker
+
+// startProgress starts the progress view on stdout. On a terminal log lines
+// are routed through the view so they are printed above the bars. The
+// returned function stops the view. With --json stdout is left to the
+// machine-readable output, with --dry-run to the plan, with --quiet nothing is
+// shown, and with --log-file log lines keep going to the file.
+func startProgress() func() {
+	if jsonOutput || dryRun || quiet {
+		return func() {}
+	}
+	tracker = progress.New(os.Stdout)
+	routeLogs := tracker.Interactive() && logFile == ""
+	if routeLogs {
+		log.SetColorProfile(termenv.EnvColorProfile())
+		log.SetOutput(tracker)
+	}
+	tracker.Start()
+
+	return func() {
+		tracker.Stop()
+		if routeLogs {
+			log.SetOutput(logOutput)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"party-dl/internal/ratelimit"
+	"party-dl/internal/utils"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pageRateLimit          float64
+	downloadRateLimit      float64
+	hostPageRateLimits     map[string]string
+	hostDownloadRateLimits map[string]string
+	limitRate              string
+	limitSchedule          string
+
+	// The limiters are created once per process so every creator downloaded
+	// in a run shares the same buckets.
+	limitersOnce   sync.Once
+	limitersErr    error
+	pageLimiter    *ratelimit.Limiter
+	downloadClient *http.Client
+	bandwidth      *ratelimit.Bandwidth
+)
+
+func addRateLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().Float64VarP(&pageRateLimit, "rate-limit", "", 2, "Page and API requests per second per host, 0 disables the limit")
+	cmd.Flags().Float64VarP(&downloadRateLimit, "download-rate-limit", "", 4, "File downloads started per second per host, 0 disables the limit")
+	cmd.Flags().StringToStringVarP(&hostPageRateLimits, "host-rate-limit", "", nil, "Page and API requests per second for a host, e.g. kemono.su=1")
+	cmd.Flags().StringToStringVarP(&hostDownloadRateLimits, "host-download-rate-limit", "", nil, "File downloads per second for a host, e.g. coomer.su=2")
+	cmd.Flags().StringVarP(&limitRate, "limit-rate", "", "", "Bandwidth shared by all download workers, e.g. 5M")
+	cmd.Flags().StringVarP(&limitSchedule, "limit-schedule", "", "", "Daily bandwidth windows overriding --limit-rate, e.g. 01:00-07:00=0,18:00-23:00=500K")
+}
+
+func initRateLimits(ctx context.Context) error {
+	limitersOnce.Do(func() {
+		pageOverrides, err := parseHostRates(hostPageRateLimits)
+		if err != nil {
+			limitersErr = err
+			return
+		}
+		downloadOverrides, err := parseHostRates(hostDownloadRateLimits)
+		if err != nil {
+			limitersErr = err
+			return
+		}
+
+		bytesPerSecond := int64(0)
+		if limitRate != "" {
+			bytesPerSecond, err = utils.ParseSize(limitRate)
+			if err != nil {
+				limitersErr = err
+				return
+			}
+		}
+		schedule, err := ratelimit.ParseSchedule(limitSchedule)
+		if err != nil {
+			limitersErr = err
+			return
+		}
+		bandwidth = ratelimit.NewBandwidth(bytesPerSecond)
+		if len(schedule) > 0 {
+			go bandwidth.Follow(ctx, schedule, bytesPerSecond, time.Minute)
+		}
+
+		pageLimiter = ratelimit.New(pageRateLimit, pageOverrides)
+		downloadClient = &http.Client{
+			Transport: ratelimit.NewTransport(ratelimit.New(downloadRateLimit, downloadOverrides)),
+		}
+	})
+	return usage(limitersErr)
+}
+
+func parseHostRates(hostRates map[string]string) (map[string]float64, error) {
+	rates := make(map[string]float64, len(hostRates))
+	for host, value := range hostRates {
+		perSecond, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit %q for host %s", value, host)
+		}
+		rates[host] = perSecond
+	}
+	return rates, nil
+}
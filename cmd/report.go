@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"party-dl/internal/coomer"
+	"party-dl/internal/downloader"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportFile string
+
+	// report collects the results of the running command, nil if the
+	// command doesn't write a report.
+	report *runReport
+)
+
+// runReport is the machine-readable summary of a download or stash run.
+type runReport struct {
+	mu          sync.Mutex
+	Command     string           `json:"command"`
+	Started     time.Time        `json:"started"`
+	Duration    float64          `json:"durationSeconds"`
+	ExitCode    int              `json:"exitCode"`
+	Error       string           `json:"error,omitempty"`
+	Interrupted bool             `json:"interrupted"`
+	Creators    []*creatorReport `json:"creators"`
+}
+
+// creatorReport is the result of one creator, or of a single post for the
+// favorited posts.
+type creatorReport struct {
+	Creator  string  `json:"creator"`
+	Service  string  `json:"service,omitempty"`
+	URL      string  `json:"url,omitempty"`
+	Post     string  `json:"post,omitempty"`
+	Duration float64 `json:"durationSeconds"`
+	// Download counts.
+	Posts      int   `json:"posts"`
+	Downloaded int   `json:"downloaded"`
+	Skipped    int   `json:"skipped"`
+	Existing   int   `json:"existing"`
+	Filtered   int   `json:"filtered"`
+	Failed     int   `json:"failed"`
+	Bytes      int64 `json:"bytes"`
+	// Stash counts.
+	Updated  int       `json:"updated"`
+	Failures []failure `json:"failures"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func addReportFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Write machine-readable output to stdout")
+	cmd.Flags().StringVarP(&reportFile, "report", "", "", "Write a JSON report of the run to this file")
+}
+
+// startReport starts collecting the report of command if one was asked for.
+func startReport(command string) {
+	if reportFile == "" && !jsonOutput {
+		return
+	}
+	report = &runReport{Command: command, Started: time.Now(), Creators: []*creatorReport{}}
+}
+
+// addDownload adds the result of a creator's pipeline to the report. post is
+// set when only a single post was downloaded.
+func (r *runReport) addDownload(info *coomer.CreatorInfo, url, post string, started time.Time, posts *pipeline, downloadManager *downloader.Downloader) {
+	if r == nil {
+		return
+	}
+	stats := posts.Stats()
+	creator := &creatorReport{
+		Creator:    info.Name,
+		Service:    info.Service,
+		URL:        url,
+		Post:       post,
+		Duration:   time.Since(started).Seconds(),
+		Posts:      stats.Scraped,
+		Downloaded: stats.Downloaded,
+		Skipped:    stats.Existing + stats.Filtered,
+		Existing:   stats.Existing,
+		Filtered:   stats.Filtered,
+		Failed:     stats.Failed + stats.FailedPosts + stats.StashFailed,
+		Bytes:      downloadManager.Transferred(),
+		Updated:    stats.StashUpdated,
+		Failures:   posts.Failures(),
+	}
+	r.add(creator)
+}
+
+// addStash adds the result of a creator's metadata file to the report.
+func (r *runReport) addStash(name, service, url string, started time.Time, stats stashStats) {
+	r.add(&creatorReport{
+		Creator:  name,
+		Service:  service,
+		URL:      url,
+		Duration: time.Since(started).Seconds(),
+		Updated:  stats.Updated,
+		Failed:   stats.Failed,
+		Failures: append([]failure{}, stats.Failures...),
+	})
+}
+
+// addError adds a creator that failed as a whole to the report.
+func (r *runReport) addError(name, url string, started time.Time, err error) {
+	if r == nil {
+		return
+	}
+	r.add(&creatorReport{
+		Creator:  name,
+		URL:      url,
+		Duration: time.Since(started).Seconds(),
+		Failures: []failure{},
+		Error:    err.Error(),
+	})
+}
+
+func (r *runReport) add(creator *creatorReport) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.Creators = append(r.Creators, creator)
+	r.mu.Unlock()
+}
+
+// finishReport writes the report with the command's result to --report and,
+// with --json, to stdout.
+func finishReport(err error) error {
+	r := report
+	if r == nil {
+		return nil
+	}
+	r.Duration = time.Since(r.Started).Seconds()
+	r.ExitCode = ExitCode(err)
+	r.Interrupted = errors.Is(err, errInterrupted)
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	if reportFile != "" {
+		file, err := os.Create(reportFile)
+		if err != nil {
+			return err
+		}
+		if err := r.write(file); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	// A dry run already writes its plan to stdout.
+	if jsonOutput && !dryRun {
+		return r.write(os.Stdout)
+	}
+	return nil
+}
+
+func (r *runReport) write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"party-dl/internal/coomer"
+	"party-dl/internal/downloader"
+	"party-dl/internal/metadata"
+	"testing"
+	"time"
+)
+
+func TestReportFailuresAreLists(t *testing.T) {
+	r := &runReport{Command: "download", Started: time.Now(), Creators: []*creatorReport{}}
+	info := &coomer.CreatorInfo{Name: "alice", Service: "onlyfans"}
+	downloadManager := downloader.NewDownloader(t.TempDir(), metadata.CreatorInfo{Name: "alice"})
+
+	succeeded := &pipeline{}
+	r.addDownload(info, "https://coomer.su/onlyfans/user/alice", "", time.Now(), succeeded, downloadManager)
+	failed := &pipeline{failures: []failure{{URL: "https://coomer.su/data/a.mp4", Reason: "500 Internal Server Error"}}}
+	r.addDownload(info, "https://coomer.su/onlyfans/user/alice", "", time.Now(), failed, downloadManager)
+	r.addStash("alice", "onlyfans", "https://coomer.su/onlyfans/user/alice", time.Now(), stashStats{Updated: 1})
+	r.addError("bob", "https://coomer.su/onlyfans/user/bob", time.Now(), errors.New("creator not found"))
+
+	var buf bytes.Buffer
+	if err := r.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Creators []map[string]json.RawMessage `json:"creators"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	wantFailures := []int{0, 1, 0, 0}
+	if len(decoded.Creators) != len(wantFailures) {
+		t.Fatalf("report has %d creators, want %d", len(decoded.Creators), len(wantFailures))
+	}
+	for i, creator := range decoded.Creators {
+		var failures []failure
+		if raw := creator["failures"]; !bytes.HasPrefix(raw, []byte("[")) {
+			t.Errorf("creator %d: failures = %s, want a list", i, raw)
+			continue
+		}
+		if err := json.Unmarshal(creator["failures"], &failures); err != nil {
+			t.Fatal(err)
+		}
+		if len(failures) != wantFailures[i] {
+			t.Errorf("creator %d: %d failures, want %d", i, len(failures), wantFailures[i])
+		}
+	}
+}
@@ -2,28 +2,74 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
+// Execute runs the command line and logs its error. Use ExitCode to map the
+// error to the process's exit code.
 func Execute() error {
 	rootCmd := &cobra.Command{
 		Version: "v0.0.1",
 		Use:     "party-dl",
 		Long:    "party-dl is a tool for downloading content from the .party sites",
+		Args:    usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+		// Errors are logged by Execute, usage is only shown for usage errors.
+		SilenceErrors: true,
+		SilenceUsage:  true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			if err := loadConfig(cmd); err != nil {
 				return err
 			}
-			viper.AutomaticEnv()
-			viper.SetEnvPrefix("party-dl")
+			runCommand = cmd.CommandPath()
 
-			return nil
+			return setupLogging()
 		},
 	}
 
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usage(err)
+	})
+	addConfigFlags(rootCmd)
+	addLogFlags(rootCmd)
+	rootCmd.PersistentFlags().BoolVarP(&waitLock, "wait-lock", "", false, "Wait for creator directories locked by another run instead of failing")
+
 	rootCmd.AddCommand(downloadCmd())
 	rootCmd.AddCommand(stashCmd())
+	rootCmd.AddCommand(favoritesCmd())
 
-	return rootCmd.ExecuteContext(context.Background())
+	// The first interrupt cancels the context so running downloads can stop
+	// cleanly, a second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	defer closeLogFile()
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	if reportErr := finishReport(err); reportErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to write report: %w", reportErr))
+	}
+	var usageErr *usageError
+	switch {
+	case err == nil, errors.Is(err, errInterrupted):
+		// An interrupt is already reported by the command's summary.
+	case errors.As(err, &usageErr):
+		log.Error(err)
+		fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", cmd.CommandPath())
+	default:
+		log.Error(err)
+	}
+	return err
 }
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"party-dl/internal/coomer"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	pageSize       = 50
+	pageRetries    = 3
+	pageRetryDelay = 2 * time.Second
+)
+
+type pageResult struct {
+	posts []coomer.Post
+	err   error
+}
+
+// scrapePosts scrapes the creator's pages and hands every post to submit in
+// page order. The pages known from the creator's post count are fetched
+// concurrently, pages after them are scraped one by one until the site
+// redirects, so posts added since the count was read are not missed.
+func scrapePosts(ctx context.Context, logger *log.Logger, coomerManager *coomer.Manager, url string, totalPosts int, submit func(coomer.Post)) error {
+	pages := (totalPosts + pageSize - 1) / pageSize
+	seen := make(map[string]bool)
+	scraped := 0
+	submitPage := func(posts []coomer.Post) {
+		for _, post := range posts {
+			// Posts shift to the next page when new ones are published mid-scrape.
+			if seen[post.URL] {
+				continue
+			}
+			seen[post.URL] = true
+			submit(post)
+			scraped++
+		}
+	}
+
+	results := make([]chan pageResult, pages)
+	for i := range results {
+		results[i] = make(chan pageResult, 1)
+	}
+	// Like the pond pools, run at least one worker.
+	threads := max(numThreads, 1)
+	// window bounds how many pages are fetched ahead of the one being submitted.
+	window := make(chan struct{}, threads*2)
+	stop := make(chan struct{})
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i := 0; i < pages; i++ {
+			select {
+			case window <- struct{}{}:
+			case <-stop:
+				return
+			}
+			select {
+			case indexes <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for w := 0; w < threads; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range indexes {
+				expected := pageSize
+				if i == pages-1 {
+					expected = totalPosts - i*pageSize
+				}
+				posts, err := scrapeFullPage(ctx, logger, coomerManager, url, i, expected)
+				results[i] <- pageResult{posts: posts, err: err}
+			}
+		}()
+	}
+	defer workers.Wait()
+	defer close(stop)
+
+	for i := 0; i < pages; i++ {
+		result := <-results[i]
+		<-window
+		if result.err != nil {
+			return result.err
+		}
+		logger.Info("Scraped page", "page", i+1, "pages", pages)
+		submitPage(result.posts)
+	}
+
+	for scrapeIndex := pages; ctx.Err() == nil; scrapeIndex++ {
+		logger.Info("Scraping page", "page", scrapeIndex+1)
+		pagePosts, done, err := coomerManager.ScrapePage(ctx, url, scrapeIndex)
+		if err != nil {
+			return err
+		}
+		if done || len(pagePosts) == 0 {
+			logger.Info("Page doesn't exist, finished scraping", "page", scrapeIndex+1)
+			break
+		}
+		submitPage(pagePosts)
+	}
+
+	logger.Info("Finished scraping", "posts", scraped)
+	return nil
+}
+
+// scrapeFullPage scrapes a page that should hold expected posts, retrying
+// while it comes back empty or short.
+func scrapeFullPage(ctx context.Context, logger *log.Logger, coomerManager *coomer.Manager, url string, index, expected int) ([]coomer.Post, error) {
+	var posts []coomer.Post
+	for attempt := 0; ; attempt++ {
+		pagePosts, done, err := coomerManager.ScrapePage(ctx, url, index)
+		if err != nil {
+			return nil, err
+		}
+		if len(pagePosts) > len(posts) {
+			posts = pagePosts
+		}
+		if !done && len(posts) >= expected {
+			return posts, nil
+		}
+		if attempt == pageRetries {
+			logger.Warn("Page is still short, continuing", "page", index+1, "posts", len(posts), "expected", expected)
+			return posts, nil
+		}
+		logger.Warn("Page came back short, retrying", "page", index+1, "posts", len(pagePosts), "expected", expected)
+		select {
+		case <-time.After(pageRetryDelay * time.Duration(attempt+1)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
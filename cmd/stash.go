@@ -1,19 +1,34 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 	"os"
+	"party-dl/internal/downloader"
+	"party-dl/internal/fingerprint"
 	"party-dl/internal/metadata"
 	stash2 "party-dl/internal/stash"
 	"path/filepath"
 	"time"
 )
 
+const stashJobPollInterval = 2 * time.Second
+
 var (
-	stashHost = ""
-	content   = ""
+	stashHost     = ""
+	stashAPIKey   = ""
+	content       = ""
+	stashScan     bool
+	stashGenerate bool
+	stashPathMap  []string
+	syncToStash   bool
+
+	// stashSync adds the files downloaded by the running command to stash,
+	// nil unless --stash is set.
+	stashSync *stash2.Manager
 )
 
 func stashCmd() *cobra.Command {
@@ -22,117 +37,482 @@ func stashCmd() *cobra.Command {
 		Short:   "add metadata to stash",
 		Example: "party-dl stash --stash-host http://localhost:9999 --content ./data/",
 		Aliases: []string{"s", "stash"},
+		Args:    usageArgs(cobra.NoArgs),
 		RunE:    stash,
 	}
-	cmd.Flags().StringVarP(&stashHost, "stash-host", "", "", "Stash host")
 	cmd.Flags().StringVarP(&content, "content", "c", "", "Path to your stash content folder")
+	cmd.Flags().BoolVarP(&stashScan, "scan", "", false, "Scan the creator directories in stash and wait for it before adding metadata")
+	addStashFlags(cmd)
+	addReportFlags(cmd)
 	return cmd
 }
 
-func stash(cmd *cobra.Command, args []string) error {
+func addStashFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&stashHost, "stash-host", "", "", "Stash host")
+	cmd.Flags().StringVarP(&stashAPIKey, "stash-api-key", "", "", "Stash API key, if authentication is turned on")
+	cmd.Flags().BoolVarP(&stashGenerate, "generate", "", false, "Generate covers and phashes of the scenes before adding metadata")
+	cmd.Flags().StringArrayVarP(&stashPathMap, "stash-path-map", "", nil, "Translate a local directory to the directory stash sees it as, e.g. /mnt/nas=/data (repeatable)")
+	addStashTagFlags(cmd)
+}
+
+// addStashSyncFlags adds the flags to add the downloaded files to stash.
+func addStashSyncFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&syncToStash, "stash", "", false, "Scan the downloaded files in stash and add their metadata once a creator is done")
+	addStashFlags(cmd)
+}
+
+// newStashManager returns the manager for the stash given by the flags.
+func newStashManager() (*stash2.Manager, error) {
 	if stashHost == "" {
-		log.Error("no stash host specified")
+		return nil, usageErrorf("no stash host specified, set --stash-host")
+	}
+	pathMap, err := stash2.ParsePathMap(stashPathMap)
+	if err != nil {
+		return nil, usage(err)
+	}
+	if err := parseStashTagFlags(); err != nil {
+		return nil, err
+	}
+	stashManager := stash2.NewManager(stashHost, stashAPIKey)
+	stashManager.PathMap = pathMap
+	return stashManager, nil
+}
+
+// startStashSync sets up stashSync if --stash is set. A dry run doesn't
+// download anything to add.
+func startStashSync() error {
+	if !syncToStash || dryRun {
+		return nil
+	}
+	stashManager, err := newStashManager()
+	if err != nil {
+		return err
+	}
+	stashSync = stashManager
+	return nil
+}
+
+func stash(cmd *cobra.Command, args []string) error {
+	startReport("stash")
+	stashManager, err := newStashManager()
+	if err != nil {
+		return err
 	}
 	if content == "" {
-		log.Error("no content specified")
+		return usageErrorf("no content specified, set --content")
 	}
 
-	stashManager := stash2.NewManager(stashHost)
+	ctx := cmd.Context()
 
-	log.Infof("Searching for metadata files...")
+	log.Info("Searching for metadata files", "dir", content)
 
 	metaFiles, err := findMetadataJSONFiles(content)
 	if err != nil {
-		log.Error(err)
-		return nil
+		return err
 	}
 
-	log.Infof("Found %d metadata files", len(metaFiles))
+	log.Info("Found metadata files", "files", len(metaFiles))
 
+	dirs := make([]string, 0, len(metaFiles))
 	for _, metaFile := range metaFiles {
-		log.Infof("Adding metadata to stash: %s", metaFile)
-		meta, err := metadata.ReadMetadata(metaFile)
+		dir, err := filepath.Abs(filepath.Dir(metaFile))
 		if err != nil {
-			log.Error(err)
-			continue
+			return err
 		}
-		studioName := ""
-		studioUrl := ""
-		switch meta.Creator.Service {
-		case "onlyfans":
-			studioName = "OnlyFans"
-			studioUrl = "https://onlyfans.com"
-		case "fansly":
-			studioName = "Fansly"
-			studioUrl = "https://fansly.com"
-		}
-		studioId, err := stashManager.GetOrCreateStudio(studioName, studioUrl)
+		dirs = append(dirs, dir)
+	}
+	if err := prepareStash(ctx, stashManager, dirs, stashScan); err != nil {
+		return interrupted(ctx, err)
+	}
+
+	// A metadata file that couldn't be processed at all counts as one
+	// failed update.
+	var total stashStats
+	for i, metaFile := range metaFiles {
+		if ctx.Err() != nil {
+			log.Warnf("Interrupted, added metadata of %d of %d metadata files", i, len(metaFiles))
+			return errInterrupted
+		}
+		log.Info("Adding metadata to stash", "file", metaFile)
+		started := time.Now()
+		creatorLock, err := lockCreator(ctx, filepath.Dir(metaFile))
 		if err != nil {
-			log.Error(err)
+			if ctx.Err() == nil {
+				log.Error(err)
+				report.addError(filepath.Base(filepath.Dir(metaFile)), metaFile, started, err)
+				total.Failed++
+			}
 			continue
 		}
-		log.Info("Found/Created studio", "id", studioId, "name", studioName, "url", studioUrl)
+		creator, stats, err := stashCreator(ctx, stashManager, metaFile, nil)
+		unlockCreator(creatorLock)
+		// Every other request would fail the same way.
+		var authErr *stash2.AuthError
+		if errors.As(err, &authErr) {
+			return authErr
+		}
+		if err != nil && ctx.Err() == nil {
+			stats.fail(log.With("creator", creator.Name), metaFile, err)
+		}
+		report.addStash(creator.Name, creator.Service, creator.PageLink, started, stats)
+		total.Updated += stats.Updated
+		total.Failed += stats.Failed
+	}
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+
+	log.Info("Summary", "updated", total.Updated, "failed", total.Failed)
+	if total.Failed > 0 {
+		return &partialError{Failed: total.Failed, Total: total.Updated + total.Failed, What: "stash updates"}
+	}
+	return nil
+}
 
-		performerId, err := stashManager.GetOrCreatePerformer(meta.Creator.Name, meta.Creator.PageLink)
+// prepareStash scans paths, if scan is set, and generates their scenes, if
+// --generate is set, and waits for the jobs, so the files are known to stash
+// before their metadata is added. paths are absolute directories or files.
+func prepareStash(ctx context.Context, stashManager *stash2.Manager, paths []string, scan bool) error {
+	if !scan && !stashGenerate || len(paths) == 0 {
+		return nil
+	}
+
+	if scan {
+		log.Info("Scanning in stash", "paths", len(paths))
+		jobID, err := stashManager.Scan(ctx, paths)
 		if err != nil {
-			log.Error(err)
-			continue
+			return err
+		}
+		if err := waitForStashJob(ctx, stashManager, jobID); err != nil {
+			return err
 		}
-		log.Info("Found/Created performer", "id", performerId, "name", meta.Creator.Name, "url", meta.Creator.PageLink)
+	}
 
-		for _, file := range meta.Files {
-			scene, found, err := stashManager.GetSceneByPathAndSize(file.FileName, file.Size)
+	if stashGenerate {
+		var sceneIDs []string
+		for _, path := range paths {
+			ids, err := stashManager.FindSceneIDsInPath(ctx, path)
 			if err != nil {
-				log.Error(err)
-				continue
-			}
-			if !found {
-				continue
-			}
-			updateInput := stash2.UpdateInput{
-				ID:          scene[5].(float64),
-				Date:        file.Published.Format(time.RFC3339),
-				StudioID:    studioId,
-				Details:     file.Description,
-				Title:       fmt.Sprintf("%s - %s", meta.Creator.Name, file.Published.Format(time.DateOnly)),
-				PerformerID: performerId,
-			}
-			_, err = stashManager.UpdateScene(updateInput)
-			if err != nil {
-				log.Error(err)
-				continue
+				return err
 			}
-			log.Infof("Added metadata to scene: %s", file.FileName)
+			sceneIDs = append(sceneIDs, ids...)
 		}
+		if len(sceneIDs) == 0 {
+			return nil
+		}
+		log.Info("Generating covers and phashes in stash", "scenes", len(sceneIDs))
+		jobID, err := stashManager.Generate(ctx, sceneIDs)
+		if err != nil {
+			return err
+		}
+		if err := waitForStashJob(ctx, stashManager, jobID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func waitForStashJob(ctx context.Context, stashManager *stash2.Manager, jobID string) error {
+	err := stashManager.WaitForJob(ctx, jobID, stashJobPollInterval, func(job stash2.Job) {
+		progress := 0.0
+		if job.Progress != nil {
+			progress = *job.Progress * 100
+		}
+		log.Debug("Waiting for stash job", "job", job.Description, "status", job.Status, "progress", fmt.Sprintf("%.0f%%", progress))
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("Stash job finished", "id", jobID)
+	return nil
+}
 
+// stashStats counts the scenes and images a stash run updated.
+type stashStats struct {
+	Updated  int
+	Failed   int
+	Failures []failure
+}
+
+func (s *stashStats) fail(logger *log.Logger, url string, err error) {
+	logger.Error("Failed to add metadata", "url", url, "err", err)
+	s.Failed++
+	s.Failures = append(s.Failures, failure{URL: url, Reason: err.Error()})
+}
+
+// stashCreator adds the metadata of one creator's metadata file to stash. If
+// only isn't nil, only files whose download URL is in it are updated. Failed
+// lookups and updates of single files are logged and counted, the error is
+// reserved for failures affecting the whole creator.
+func stashCreator(ctx context.Context, stashManager *stash2.Manager, metaFile string, only map[string]bool) (metadata.CreatorInfo, stashStats, error) {
+	var stats stashStats
+	meta, err := metadata.ReadMetadata(metaFile)
+	if err != nil {
+		// The directory is named after the creator.
+		return metadata.CreatorInfo{Name: filepath.Base(filepath.Dir(metaFile))}, stats, err
+	}
+	logger := log.With("creator", meta.Creator.Name)
+	dir, err := filepath.Abs(filepath.Dir(metaFile))
+	if err != nil {
+		return meta.Creator, stats, err
+	}
+
+	studioName := ""
+	studioUrl := ""
+	switch meta.Creator.Service {
+	case "onlyfans":
+		studioName = "OnlyFans"
+		studioUrl = "https://onlyfans.com"
+	case "fansly":
+		studioName = "Fansly"
+		studioUrl = "https://fansly.com"
+	}
+	studioId, err := stashManager.GetOrCreateStudio(ctx, studioName, studioUrl)
+	if err != nil {
+		return meta.Creator, stats, err
+	}
+	logger.Info("Found/Created studio", "id", studioId, "name", studioName, "url", studioUrl)
+
+	performerId, err := stashManager.GetOrCreatePerformer(ctx, meta.Creator.Name, meta.Creator.PageLink)
+	if err != nil {
+		return meta.Creator, stats, err
+	}
+	logger.Info("Found/Created performer", "id", performerId, "url", meta.Creator.PageLink)
+
+	files := meta.Files
+	if only != nil {
+		files = nil
 		for _, file := range meta.Files {
-			scene, found, err := stashManager.GetImageByPathAndSize(file.FileName, file.Size)
-			if err != nil {
-				log.Error(err)
-				continue
+			if only[file.DownloadURL] {
+				files = append(files, file)
 			}
-			if !found {
-				continue
-			}
-			updateInput := stash2.UpdateInput{
-				ID:          scene[5].(float64),
-				Date:        file.Published.Format(time.RFC3339),
-				StudioID:    studioId,
-				Details:     file.Description,
-				Title:       fmt.Sprintf("%s - %s", meta.Creator.Name, file.Published.Format(time.DateOnly)),
-				PerformerID: performerId,
+		}
+	}
+
+	fingerprintFiles(logger, dir, metaFile, files)
+	tagger := newStashTagger(stashManager)
+
+	for _, file := range files {
+		scene, found, err := findScene(ctx, stashManager, mediaPath(dir, file), file)
+		if err != nil {
+			stats.fail(logger, file.DownloadURL, err)
+			continue
+		}
+		if !found {
+			continue
+		}
+		tagIDs, err := tagger.TagIDs(ctx, meta.Creator, file)
+		if err != nil {
+			stats.fail(logger, file.DownloadURL, err)
+			continue
+		}
+		updateInput := stash2.UpdateInput{
+			ID:          scene.ID,
+			Date:        file.Published.Format(time.RFC3339),
+			StudioID:    studioId,
+			Details:     file.Description,
+			Title:       fmt.Sprintf("%s - %s", meta.Creator.Name, file.Published.Format(time.DateOnly)),
+			URLs:        sourceURLs(meta.Creator, file),
+			Code:        file.PostID,
+			PerformerID: performerId,
+			TagIDs:      tagIDs,
+		}
+		_, err = stashManager.UpdateScene(ctx, updateInput)
+		if err != nil {
+			stats.fail(logger, file.DownloadURL, err)
+			continue
+		}
+		logger.Info("Added metadata to scene", "url", file.DownloadURL, "file", file.FileName)
+		stats.Updated++
+	}
+
+	// The images are grouped into a gallery per post, posts holds the first
+	// file of each post.
+	var posts []metadata.FileInfo
+	postImages := make(map[string][]string)
+	for _, file := range files {
+		image, found, err := findImage(ctx, stashManager, mediaPath(dir, file), file)
+		if err != nil {
+			stats.fail(logger, file.DownloadURL, err)
+			continue
+		}
+		if !found {
+			continue
+		}
+		tagIDs, err := tagger.TagIDs(ctx, meta.Creator, file)
+		if err != nil {
+			stats.fail(logger, file.DownloadURL, err)
+			continue
+		}
+		updateInput := stash2.UpdateInput{
+			ID:          image.ID,
+			Date:        file.Published.Format(time.RFC3339),
+			StudioID:    studioId,
+			Details:     file.Description,
+			Title:       fmt.Sprintf("%s - %s", meta.Creator.Name, file.Published.Format(time.DateOnly)),
+			URLs:        sourceURLs(meta.Creator, file),
+			Code:        file.PostID,
+			PerformerID: performerId,
+			TagIDs:      tagIDs,
+		}
+		_, err = stashManager.UpdateImage(ctx, updateInput)
+		if err != nil {
+			stats.fail(logger, file.DownloadURL, err)
+			continue
+		}
+		logger.Info("Added metadata to image", "url", file.DownloadURL, "file", file.FileName)
+		stats.Updated++
+		// Files downloaded by older versions don't know their post.
+		if file.PostURL != "" {
+			if _, ok := postImages[file.PostURL]; !ok {
+				posts = append(posts, file)
 			}
-			_, err = stashManager.UpdateImage(updateInput)
-			if err != nil {
-				log.Error(err)
-				continue
+			postImages[file.PostURL] = append(postImages[file.PostURL], image.ID)
+		}
+	}
+
+	for _, post := range posts {
+		gallery := stash2.GalleryInput{
+			Title:        post.Title,
+			Date:         post.Published.Format(time.DateOnly),
+			Details:      post.Description,
+			Code:         post.PostID,
+			StudioID:     studioId,
+			PerformerIDs: []string{performerId},
+		}
+		if meta.Creator.PageLink != "" {
+			gallery.URLs = []string{meta.Creator.PageLink}
+		}
+		if gallery.Title == "" {
+			gallery.Title = fmt.Sprintf("%s - %s", meta.Creator.Name, post.Published.Format(time.DateOnly))
+		}
+		gallery.TagIDs, err = tagger.TagIDs(ctx, meta.Creator, post)
+		if err != nil {
+			stats.fail(logger, post.PostURL, err)
+			continue
+		}
+		galleryID, err := stashManager.GetOrCreateGallery(ctx, post.PostURL, gallery)
+		if err == nil {
+			err = stashManager.AddGalleryImages(ctx, galleryID, postImages[post.PostURL])
+		}
+		if err != nil {
+			stats.fail(logger, post.PostURL, err)
+			continue
+		}
+		logger.Info("Added images to gallery", "post", post.PostURL, "id", galleryID, "images", len(postImages[post.PostURL]))
+	}
+
+	return meta.Creator, stats, nil
+}
+
+// mediaPath returns the path of file in the creator directory dir.
+func mediaPath(dir string, file metadata.FileInfo) string {
+	return filepath.Join(dir, downloader.MediaType(file.DownloadURL), file.FileName)
+}
+
+// sourceURLs returns the URLs of the post of file and of the creator's page
+// on the service, whichever are known.
+func sourceURLs(creator metadata.CreatorInfo, file metadata.FileInfo) []string {
+	var urls []string
+	for _, url := range []string{file.PostURL, creator.PageLink} {
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// syncCreator adds the files with the download URLs urls, downloaded to the
+// creator directory dir by this run, to stash. The files are scanned first,
+// as stash doesn't know them yet.
+func syncCreator(ctx context.Context, stashManager *stash2.Manager, logger *log.Logger, dir string, urls []string) (stashStats, error) {
+	if len(urls) == 0 {
+		return stashStats{}, nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return stashStats{}, err
+	}
+	metaFile := filepath.Join(dir, "metadata.json")
+	meta, err := metadata.ReadMetadata(metaFile)
+	if err != nil {
+		return stashStats{}, err
+	}
+
+	added := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		added[url] = true
+	}
+	var paths []string
+	for _, file := range meta.Files {
+		if added[file.DownloadURL] {
+			paths = append(paths, mediaPath(dir, file))
+		}
+	}
+
+	logger.Info("Adding downloaded files to stash", "files", len(paths))
+	if err := prepareStash(ctx, stashManager, paths, true); err != nil {
+		return stashStats{}, err
+	}
+	_, stats, err := stashCreator(ctx, stashManager, metaFile, added)
+	if err == nil {
+		logger.Info("Added downloaded files to stash", "updated", stats.Updated, "failed", stats.Failed)
+	}
+	return stats, err
+}
+
+// fingerprintFiles computes the fingerprints missing from the files, which
+// were downloaded before they were recorded, sets them on the files and
+// caches them in the metadata file.
+func fingerprintFiles(logger *log.Logger, dir, metaFile string, files []metadata.FileInfo) {
+	computed := make(map[string]fingerprint.Sums)
+	for i := range files {
+		file := &files[i]
+		if file.OSHash != "" && file.MD5 != "" {
+			continue
+		}
+		sums, err := fingerprint.File(mediaPath(dir, *file))
+		if err != nil {
+			logger.Warn("Failed to fingerprint file, matching it by name", "url", file.DownloadURL, "err", err)
+			continue
+		}
+		file.SHA256, file.MD5, file.OSHash = sums.SHA256, sums.MD5, sums.OSHash
+		computed[file.DownloadURL] = sums
+	}
+	if len(computed) == 0 {
+		return
+	}
+
+	err := metadata.UpdateMetadata(metaFile, func(meta *metadata.Metadata) {
+		for i := range meta.Files {
+			if sums, ok := computed[meta.Files[i].DownloadURL]; ok {
+				meta.Files[i].SHA256, meta.Files[i].MD5, meta.Files[i].OSHash = sums.SHA256, sums.MD5, sums.OSHash
 			}
-			log.Infof("Added metadata to image: %s", file.FileName)
 		}
+	})
+	if err != nil {
+		logger.Warn("Failed to cache fingerprints", "file", metaFile, "err", err)
+	}
+}
 
+// findScene looks up the scene of file by fingerprint, falling back to its
+// path and size for files stash doesn't know by these fingerprints.
+func findScene(ctx context.Context, stashManager *stash2.Manager, path string, file metadata.FileInfo) (*stash2.Scene, bool, error) {
+	scene, found, err := stashManager.FindSceneByHash(ctx, file.OSHash, file.MD5)
+	if err != nil || found {
+		return scene, found, err
 	}
+	return stashManager.GetSceneByPathAndSize(ctx, path, file.Size)
+}
 
-	return nil
+// findImage looks up the image of file by MD5, falling back to its path and
+// size.
+func findImage(ctx context.Context, stashManager *stash2.Manager, path string, file metadata.FileInfo) (*stash2.Image, bool, error) {
+	image, found, err := stashManager.FindImageByChecksum(ctx, file.MD5)
+	if err != nil || found {
+		return image, found, err
+	}
+	return stashManager.GetImageByPathAndSize(ctx, path, file.Size)
 }
 
 func findMetadataJSONFiles(directory string) ([]string, error) {
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"party-dl/internal/metadata"
+	stash2 "party-dl/internal/stash"
+	"path/filepath"
+	"testing"
+)
+
+// emptyStashResponse answers every query of stashCreator: the studio and
+// performer exist, no scene or image is found.
+const emptyStashResponse = `{"data": {
+	"findStudios": {"count": 1, "studios": [{"id": "1"}]},
+	"findPerformers": {"count": 1, "performers": [{"id": "2"}]},
+	"findSceneByHash": null,
+	"findScenes": {"count": 0, "scenes": []},
+	"findImages": {"count": 0, "images": []}
+}}`
+
+func TestStashCreatorOnlyFingerprintsSelectedFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(emptyStashResponse))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	metaFile := filepath.Join(dir, "metadata.json")
+	creator := metadata.CreatorInfo{Name: "alice", Service: "onlyfans"}
+	urls := []string{"https://n1.coomer.su/data/new.mp4", "https://n1.coomer.su/data/old.mp4"}
+	if err := os.Mkdir(filepath.Join(dir, "videos"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range urls {
+		name := filepath.Base(url)
+		if err := os.WriteFile(filepath.Join(dir, "videos", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		file := metadata.FileInfo{FileName: name, Size: int64(len(name)), DownloadURL: url}
+		if err := metadata.AppendMetadata(metaFile, file, creator); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stashManager := stash2.NewManager(server.URL, "")
+	_, stats, err := stashCreator(context.Background(), stashManager, metaFile, map[string]bool{urls[0]: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Failed != 0 {
+		t.Errorf("stashCreator() failed %d files: %+v", stats.Failed, stats.Failures)
+	}
+
+	meta, err := metadata.ReadMetadata(metaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range meta.Files {
+		fingerprinted := file.MD5 != "" && file.OSHash != ""
+		if want := file.DownloadURL == urls[0]; fingerprinted != want {
+			t.Errorf("%s fingerprinted = %v, want %v", file.FileName, fingerprinted, want)
+		}
+	}
+}
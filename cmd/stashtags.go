@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"party-dl/internal/downloader"
+	"party-dl/internal/metadata"
+	stash2 "party-dl/internal/stash"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	stashTags        []string
+	stashPostTags    bool
+	stashKeywordTags []string
+
+	keywordTags []keywordTag
+)
+
+// keywordTag tags the files of posts whose title or description matches
+// Pattern.
+type keywordTag struct {
+	Pattern *regexp.Regexp
+	Tag     string
+}
+
+func addStashTagFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&stashTags, "stash-tag", "", []string{"{service}", "{site}"}, "Tag every scene, image and gallery with this tag, {service}, {site} and {type} are replaced by the creator's service, coomer or kemono and the media type (repeatable)")
+	cmd.Flags().BoolVarP(&stashPostTags, "stash-post-tags", "", true, "Tag scenes, images and galleries with the tags of their post")
+	cmd.Flags().StringArrayVarP(&stashKeywordTags, "stash-keyword-tag", "", nil, "Tag the files of posts whose title or description matches a case-insensitive regex, e.g. 'pov=POV' (repeatable)")
+}
+
+func parseStashTagFlags() error {
+	keywordTags = nil
+	for _, rule := range stashKeywordTags {
+		i := strings.LastIndex(rule, "=")
+		if i <= 0 || i == len(rule)-1 {
+			return usageErrorf("invalid keyword tag %q, expected REGEX=TAG", rule)
+		}
+		pattern, err := regexp.Compile("(?i)" + rule[:i])
+		if err != nil {
+			return usageErrorf("invalid keyword tag %q: %v", rule, err)
+		}
+		keywordTags = append(keywordTags, keywordTag{Pattern: pattern, Tag: rule[i+1:]})
+	}
+	return nil
+}
+
+// stashTagger resolves the tags of files to stash tag IDs. Missing tags are
+// created and the IDs are cached for the run.
+type stashTagger struct {
+	stashManager *stash2.Manager
+	ids          map[string]string
+}
+
+func newStashTagger(stashManager *stash2.Manager) *stashTagger {
+	return &stashTagger{stashManager: stashManager, ids: make(map[string]string)}
+}
+
+// TagIDs returns the IDs of the tags of file, which belongs to creator.
+func (t *stashTagger) TagIDs(ctx context.Context, creator metadata.CreatorInfo, file metadata.FileInfo) ([]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, name := range fileTags(creator, file) {
+		// Stash tag names are case-insensitive.
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		id, ok := t.ids[key]
+		if !ok {
+			var err error
+			id, err = t.stashManager.GetOrCreateTag(ctx, name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get tag %s: %w", name, err)
+			}
+			t.ids[key] = id
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// fileTags returns the names of the tags file gets from --stash-tag,
+// --stash-post-tags and --stash-keyword-tag.
+func fileTags(creator metadata.CreatorInfo, file metadata.FileInfo) []string {
+	replacer := strings.NewReplacer(
+		"{service}", creator.Service,
+		"{site}", siteName(file),
+		"{type}", downloader.MediaType(file.DownloadURL),
+	)
+	var tags []string
+	for _, tag := range stashTags {
+		tags = append(tags, replacer.Replace(tag))
+	}
+	if stashPostTags {
+		tags = append(tags, file.Tags...)
+	}
+	for _, rule := range keywordTags {
+		if rule.Pattern.MatchString(file.Title) || rule.Pattern.MatchString(file.Description) {
+			tags = append(tags, rule.Tag)
+		}
+	}
+
+	names := tags[:0]
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			names = append(names, tag)
+		}
+	}
+	return names
+}
+
+// siteName returns the name of the site file was downloaded from, e.g.
+// coomer for https://coomer.su, or "" if it isn't known.
+func siteName(file metadata.FileInfo) string {
+	link := file.PostURL
+	if link == "" {
+		link = file.DownloadURL
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	return labels[len(labels)-2]
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"party-dl/internal/metadata"
+	"reflect"
+	"testing"
+)
+
+func TestSiteName(t *testing.T) {
+	tests := []struct {
+		name string
+		file metadata.FileInfo
+		want string
+	}{
+		{"post URL", metadata.FileInfo{PostURL: "https://coomer.su/onlyfans/user/alice/post/1", DownloadURL: "https://n1.kemono.su/data/a.mp4"}, "coomer"},
+		{"download URL", metadata.FileInfo{DownloadURL: "https://n4.kemono.su/data/a.mp4"}, "kemono"},
+		{"old domain", metadata.FileInfo{PostURL: "https://coomer.party/onlyfans/user/alice/post/1"}, "coomer"},
+		{"port", metadata.FileInfo{PostURL: "https://kemono.su:443/patreon/user/1/post/2"}, "kemono"},
+		{"IP address", metadata.FileInfo{PostURL: "http://127.0.0.1:8765/onlyfans/user/alice/post/1"}, ""},
+		{"single label", metadata.FileInfo{PostURL: "http://localhost/post/1"}, ""},
+		{"no URL", metadata.FileInfo{}, ""},
+		{"invalid URL", metadata.FileInfo{PostURL: "://coomer.su"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := siteName(tt.file); got != tt.want {
+				t.Errorf("siteName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStashTagFlags(t *testing.T) {
+	defer func(rules []string) { stashKeywordTags = rules }(stashKeywordTags)
+
+	tests := []struct {
+		name    string
+		rules   []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "rules", rules: []string{"pov=POV", "behind the scenes=BTS"}, want: []string{"(?i)pov=POV", "(?i)behind the scenes=BTS"}},
+		{name: "last equals sign splits", rules: []string{"a=b=c"}, want: []string{"(?i)a=b=c"}},
+		{name: "missing tag", rules: []string{"pov="}, wantErr: true},
+		{name: "missing regex", rules: []string{"=POV"}, wantErr: true},
+		{name: "no equals sign", rules: []string{"pov"}, wantErr: true},
+		{name: "invalid regex", rules: []string{"(pov=POV"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stashKeywordTags = tt.rules
+			err := parseStashTagFlags()
+			if tt.wantErr {
+				if ExitCode(err) != ExitUsage {
+					t.Fatalf("parseStashTagFlags() = %v, want a usage error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rule := range keywordTags {
+				got = append(got, rule.Pattern.String()+"="+rule.Tag)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keywordTags = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileTags(t *testing.T) {
+	defer func(tags []string, postTags bool, rules []string) {
+		stashTags, stashPostTags, stashKeywordTags = tags, postTags, rules
+		keywordTags = nil
+	}(stashTags, stashPostTags, stashKeywordTags)
+
+	creator := metadata.CreatorInfo{Service: "onlyfans"}
+	file := metadata.FileInfo{
+		PostURL:     "https://coomer.su/onlyfans/user/alice/post/1",
+		DownloadURL: "https://n1.coomer.su/data/a.mp4",
+		Title:       "POV at the beach",
+		Description: "Behind the scenes",
+		Tags:        []string{"beach", " ", "summer"},
+	}
+	tests := []struct {
+		name     string
+		tags     []string
+		postTags bool
+		rules    []string
+		want     []string
+	}{
+		{"defaults", []string{"{service}", "{site}"}, true, nil, []string{"onlyfans", "coomer", "beach", "summer"}},
+		{"no post tags", []string{"{site}-{type}", "party-dl"}, false, nil, []string{"coomer-videos", "party-dl"}},
+		{"keyword tags", nil, false, []string{"pov=POV", "behind the scenes=BTS", "gym=Gym"}, []string{"POV", "BTS"}},
+		{"empty tags", []string{"", " {service} "}, false, nil, []string{"onlyfans"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stashTags, stashPostTags, stashKeywordTags = tt.tags, tt.postTags, tt.rules
+			if err := parseStashTagFlags(); err != nil {
+				t.Fatal(err)
+			}
+			if got := fileTags(creator, file); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fileTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package coomer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ChallengeError is returned when the site answers with an anti-bot challenge
+// or a login wall instead of the requested page.
+type ChallengeError struct {
+	URL    string
+	Reason string
+}
+
+func (e *ChallengeError) Error() string {
+	return fmt.Sprintf("challenge page returned for %s (%s), supply cookies with --cookies or --session", e.URL, e.Reason)
+}
+
+func checkChallengeResponse(res *http.Response) error {
+	if !strings.Contains(strings.ToLower(res.Header.Get("Server")), "ddos-guard") {
+		return nil
+	}
+	switch res.StatusCode {
+	case http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return &ChallengeError{URL: res.Request.URL.String(), Reason: fmt.Sprintf("ddos-guard %s", res.Status)}
+	}
+	return nil
+}
+
+func checkChallengeDocument(url string, doc *goquery.Document) error {
+	title := strings.ToLower(doc.Find("title").First().Text())
+	switch {
+	case strings.Contains(title, "ddos-guard"):
+		return &ChallengeError{URL: url, Reason: "ddos-guard page"}
+	case strings.Contains(title, "just a moment"):
+		return &ChallengeError{URL: url, Reason: "browser check page"}
+	case doc.Find("form[action$='/account/login']").Length() > 0 && doc.Find("#main").Length() == 0:
+		return &ChallengeError{URL: url, Reason: "login required"}
+	}
+	return nil
+}
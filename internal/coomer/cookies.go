@@ -0,0 +1,123 @@
+package coomer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileCookie is a cookie of a cookies.txt file with the site it belongs to.
+type fileCookie struct {
+	Site   *url.URL
+	Cookie *http.Cookie
+}
+
+// LoadCookiesFile adds every cookie of a Netscape formatted cookies.txt file
+// to the client's cookie jar.
+func (c *Manager) LoadCookiesFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cookies, err := parseCookiesFile(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	for _, cookie := range cookies {
+		c.Client.Jar.SetCookies(cookie.Site, []*http.Cookie{cookie.Cookie})
+	}
+	return nil
+}
+
+// SetSession adds a session cookie for the given site URLs and their
+// subdomains. The value may be given either raw or as "session=value".
+func (c *Manager) SetSession(value string, siteURLs ...string) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(value, "session=")
+	for _, siteURL := range siteURLs {
+		site, err := url.Parse(siteURL)
+		if err != nil || site.Host == "" {
+			continue
+		}
+		c.Client.Jar.SetCookies(&url.URL{Scheme: site.Scheme, Host: site.Host, Path: "/"}, []*http.Cookie{{
+			Name:   "session",
+			Value:  value,
+			Path:   "/",
+			Domain: site.Hostname(),
+		}})
+	}
+}
+
+// parseCookiesFile parses a Netscape formatted cookies.txt file. Cookies
+// whose include subdomains field is TRUE are domain cookies, the others are
+// only sent to their exact host.
+func parseCookiesFile(r io.Reader) ([]fileCookie, error) {
+	var cookies []fileCookie
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		// Only the line ending is trimmed, an empty value leaves a
+		// trailing tab.
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		httpOnly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httpOnly = true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		// Some exporters drop the tab before an empty value.
+		if len(fields) == 6 {
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab separated fields, got %d", lineNumber, len(fields))
+		}
+
+		host := strings.TrimPrefix(fields[0], ".")
+		if host == "" {
+			return nil, fmt.Errorf("line %d: empty domain", lineNumber)
+		}
+		secure := strings.EqualFold(fields[3], "TRUE")
+		cookie := &http.Cookie{
+			Path:     fields[2],
+			Secure:   secure,
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = host
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", lineNumber, fields[4])
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		cookies = append(cookies, fileCookie{Site: &url.URL{Scheme: scheme, Host: host, Path: "/"}, Cookie: cookie})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cookies, nil
+}
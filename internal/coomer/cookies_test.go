@@ -0,0 +1,113 @@
+package coomer
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportedCookies is a cookies.txt as written by the "Get cookies.txt
+// LOCALLY" browser extension.
+const exportedCookies = "# Netscape HTTP Cookie File\n" +
+	"# http://curl.haxx.se/rfc/cookie_spec.html\n" +
+	"# This is a generated file!  Do not edit.\n" +
+	"\n" +
+	"#HttpOnly_coomer.su\tFALSE\t/\tTRUE\t2145916800\tsession\teyJfcGVybWFuZW50Ijp0cnVlfQ\r\n" +
+	".coomer.su\tTRUE\t/\tFALSE\t0\t__ddg1_\tabc123\r\n" +
+	".kemono.su\tTRUE\t/\tTRUE\t2145916800\tthumbSize\t\r\n" +
+	"kemono.su\tFALSE\t/api\tFALSE\t2145916800\tempty\n"
+
+func TestParseCookiesFile(t *testing.T) {
+	cookies, err := parseCookiesFile(strings.NewReader(exportedCookies))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		site   string
+		cookie http.Cookie
+	}{
+		{
+			site:   "https://coomer.su/",
+			cookie: http.Cookie{Name: "session", Value: "eyJfcGVybWFuZW50Ijp0cnVlfQ", Path: "/", Secure: true, HttpOnly: true, Expires: time.Unix(2145916800, 0)},
+		},
+		{
+			site:   "http://coomer.su/",
+			cookie: http.Cookie{Name: "__ddg1_", Value: "abc123", Path: "/", Domain: "coomer.su"},
+		},
+		{
+			site:   "https://kemono.su/",
+			cookie: http.Cookie{Name: "thumbSize", Value: "", Path: "/", Domain: "kemono.su", Secure: true, Expires: time.Unix(2145916800, 0)},
+		},
+		{
+			site:   "http://kemono.su/",
+			cookie: http.Cookie{Name: "empty", Value: "", Path: "/api", Expires: time.Unix(2145916800, 0)},
+		},
+	}
+	if len(cookies) != len(tests) {
+		t.Fatalf("got %d cookies, want %d", len(cookies), len(tests))
+	}
+	for i, tt := range tests {
+		got := cookies[i]
+		if got.Site.String() != tt.site {
+			t.Errorf("cookie %d: site is %s, want %s", i, got.Site, tt.site)
+		}
+		want := tt.cookie
+		c := got.Cookie
+		if c.Name != want.Name || c.Value != want.Value || c.Path != want.Path || c.Domain != want.Domain ||
+			c.Secure != want.Secure || c.HttpOnly != want.HttpOnly || !c.Expires.Equal(want.Expires) {
+			t.Errorf("cookie %d is %+v, want %+v", i, *c, want)
+		}
+	}
+}
+
+func TestParseCookiesFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"too few fields", "coomer.su\tFALSE\t/\tTRUE\n"},
+		{"invalid expiry", "coomer.su\tFALSE\t/\tTRUE\tnever\tsession\tx\n"},
+		{"empty domain", "\tFALSE\t/\tTRUE\t0\tsession\tx\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseCookiesFile(strings.NewReader(tt.content)); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestLoadedCookiesReachSubdomains(t *testing.T) {
+	m, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies, err := parseCookiesFile(strings.NewReader(exportedCookies))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range cookies {
+		m.Client.Jar.SetCookies(cookie.Site, []*http.Cookie{cookie.Cookie})
+	}
+
+	names := func(rawURL string) map[string]bool {
+		u, _ := url.Parse(rawURL)
+		found := make(map[string]bool)
+		for _, c := range m.Client.Jar.Cookies(u) {
+			found[c.Name] = true
+		}
+		return found
+	}
+	// The host-only session cookie stays on coomer.su, the domain cookie
+	// is sent to its subdomains too.
+	if got := names("https://n1.coomer.su/data"); got["session"] || !got["__ddg1_"] {
+		t.Errorf("n1.coomer.su gets %v, want only __ddg1_", got)
+	}
+	if got := names("https://coomer.su/"); !got["session"] || !got["__ddg1_"] {
+		t.Errorf("coomer.su gets %v, want session and __ddg1_", got)
+	}
+}
@@ -1,6 +1,7 @@
 package coomer
 
 import (
+	"context"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"io"
@@ -12,6 +13,8 @@ import (
 	//tls_client "github.com/bogdanfinn/tls-client"
 	//"github.com/bogdanfinn/tls-client/profiles"
 	"net/http"
+	"net/http/cookiejar"
+	neturl "net/url"
 	"party-dl/internal/utils"
 	"strconv"
 	"strings"
@@ -41,19 +44,33 @@ type Post struct {
 	URL string
 }
 
+// ID returns the post's ID taken from its URL.
+func (p Post) ID() string {
+	return PostID(p.URL)
+}
+
 type Manager struct {
 	Client http.Client
 }
 
 type PostContent struct {
+	URL          string
+	ID           string
+	Title        string
 	DownloadURLS []string
 	Description  string
 	Published    time.Time
+	Tags         []string
 }
 
 func New() (*Manager, error) {
 	c := Manager{}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
 	client := http.Client{
+		Jar: jar,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
@@ -63,12 +80,15 @@ func New() (*Manager, error) {
 	return &c, nil
 }
 
-func (c *Manager) CreatorInfo(url string) (*CreatorInfo, error) {
-	res, err := c.Client.Get(url)
+func (c *Manager) CreatorInfo(ctx context.Context, url string) (*CreatorInfo, error) {
+	res, err := c.get(ctx, url)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
+	if err := checkChallengeResponse(res); err != nil {
+		return nil, err
+	}
 	if res.StatusCode != 200 {
 		return nil, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
 	}
@@ -77,6 +97,9 @@ func (c *Manager) CreatorInfo(url string) (*CreatorInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := checkChallengeDocument(url, doc); err != nil {
+		return nil, err
+	}
 
 	header := doc.Find("#user-header__info-top > a").First()
 	link, exists := header.Attr("href")
@@ -98,12 +121,16 @@ func (c *Manager) CreatorInfo(url string) (*CreatorInfo, error) {
 	return &CreatorInfo{Service: service, ServiceLink: link, Posts: posts, Name: strings.ToLower(name)}, nil
 }
 
-func (c *Manager) ScrapePage(url string, i int) ([]Post, bool, error) {
-	res, err := c.Client.Get(fmt.Sprintf("%s?o=%v", url, i*50))
+func (c *Manager) ScrapePage(ctx context.Context, url string, i int) ([]Post, bool, error) {
+	pageURL := fmt.Sprintf("%s?o=%v", url, i*50)
+	res, err := c.get(ctx, pageURL)
 	if err != nil {
 		return nil, false, err
 	}
 	defer res.Body.Close()
+	if err := checkChallengeResponse(res); err != nil {
+		return nil, false, err
+	}
 	if res.StatusCode != 200 && res.StatusCode != 302 {
 		return nil, false, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
 	}
@@ -116,23 +143,32 @@ func (c *Manager) ScrapePage(url string, i int) ([]Post, bool, error) {
 	if err != nil {
 		return nil, false, err
 	}
+	if err := checkChallengeDocument(pageURL, doc); err != nil {
+		return nil, false, err
+	}
 	cardList := doc.Find("#main > section > div.card-list.card-list--legacy > div.card-list__items")
+	if cardList.Length() == 0 {
+		return nil, false, &ChallengeError{URL: pageURL, Reason: "post list missing from page"}
+	}
 	cardList.Children().Each(func(i int, selection *goquery.Selection) {
 		a := selection.Children().First()
 		link, exists := a.Attr("href")
 		if exists {
-			posts = append(posts, Post{URL: link})
+			posts = append(posts, Post{URL: resolveURL(pageURL, link)})
 		}
 	})
 	return posts, false, nil
 }
 
-func (c *Manager) GetPostContent(url string) (*PostContent, error) {
-	res, err := c.Client.Get(url)
+func (c *Manager) GetPostContent(ctx context.Context, url string) (*PostContent, error) {
+	res, err := c.get(ctx, url)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
+	if err := checkChallengeResponse(res); err != nil {
+		return nil, err
+	}
 	if res.StatusCode != 200 {
 		return nil, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
 	}
@@ -141,8 +177,13 @@ func (c *Manager) GetPostContent(url string) (*PostContent, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := checkChallengeDocument(url, doc); err != nil {
+		return nil, err
+	}
+
+	postContent := PostContent{URL: url, ID: PostID(url)}
 
-	postContent := PostContent{}
+	postContent.Title = strings.TrimSpace(doc.Find("#page > header h1.post__title > span").First().Text())
 
 	postContent.Description = doc.Find("#page > div > div.post__content > pre").First().Text()
 
@@ -154,11 +195,18 @@ func (c *Manager) GetPostContent(url string) (*PostContent, error) {
 		return nil, err
 	}
 	postContent.Published = parsedTime
+
+	doc.Find("#post-tags a").Each(func(i int, selection *goquery.Selection) {
+		if tag := strings.TrimSpace(selection.Text()); tag != "" {
+			postContent.Tags = append(postContent.Tags, tag)
+		}
+	})
+
 	files := doc.Find("#page > div > div.post__files")
 	files.Children().Each(func(i int, selection *goquery.Selection) {
 		link, exists := selection.Find("a").Attr("href")
 		if exists {
-			postContent.DownloadURLS = append(postContent.DownloadURLS, link)
+			postContent.DownloadURLS = append(postContent.DownloadURLS, resolveURL(url, link))
 		}
 	})
 
@@ -166,13 +214,21 @@ func (c *Manager) GetPostContent(url string) (*PostContent, error) {
 	attachments.Children().Each(func(i int, selection *goquery.Selection) {
 		link, exists := selection.Find("a").Attr("href")
 		if exists {
-			postContent.DownloadURLS = append(postContent.DownloadURLS, link)
+			postContent.DownloadURLS = append(postContent.DownloadURLS, resolveURL(url, link))
 		}
 	})
 
 	return &postContent, nil
 }
 
+func (c *Manager) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
 func DownloadPost(postContent *PostContent, baseDir string) error {
 	for _, url := range postContent.DownloadURLS {
 		fileName := getFileNameFromURL(url)
@@ -216,6 +272,30 @@ func downloadFile(url string, filePath string) error {
 	return nil
 }
 
+// PostID returns the ID of the post at url, e.g. 123 for
+// https://coomer.su/onlyfans/user/name/post/123.
+func PostID(url string) string {
+	_, id, found := strings.Cut(url, "/post/")
+	if !found {
+		return ""
+	}
+	id, _, _ = strings.Cut(id, "?")
+	return strings.Trim(id, "/")
+}
+
+// resolveURL resolves a link found on the page at base into an absolute URL.
+func resolveURL(base, link string) string {
+	baseURL, err := neturl.Parse(base)
+	if err != nil {
+		return link
+	}
+	linkURL, err := neturl.Parse(link)
+	if err != nil {
+		return link
+	}
+	return baseURL.ResolveReference(linkURL).String()
+}
+
 func getFileNameFromURL(url string) string {
 	parts := strings.Split(url, "/")
 	return parts[len(parts)-1]
@@ -0,0 +1,86 @@
+package coomer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type FavoriteCreator struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Service string `json:"service"`
+}
+
+type FavoritePost struct {
+	ID      string `json:"id"`
+	User    string `json:"user"`
+	Service string `json:"service"`
+	Title   string `json:"title"`
+}
+
+// URL returns the creator's page on the site at baseURL.
+func (f FavoriteCreator) URL(baseURL string) string {
+	return CreatorURL(baseURL, f.Service, f.ID)
+}
+
+// CreatorURL returns the post's creator page on the site at baseURL.
+func (f FavoritePost) CreatorURL(baseURL string) string {
+	return CreatorURL(baseURL, f.Service, f.User)
+}
+
+// URL returns the post's page on the site at baseURL.
+func (f FavoritePost) URL(baseURL string) string {
+	return fmt.Sprintf("%s/post/%s", f.CreatorURL(baseURL), f.ID)
+}
+
+func CreatorURL(baseURL, service, id string) string {
+	return fmt.Sprintf("%s/%s/user/%s", strings.TrimSuffix(baseURL, "/"), service, id)
+}
+
+// FavoriteCreators returns the creators favorited by the logged-in account.
+// A session cookie has to be set on the manager.
+func (c *Manager) FavoriteCreators(ctx context.Context, baseURL string) ([]FavoriteCreator, error) {
+	var creators []FavoriteCreator
+	if err := c.getFavorites(ctx, baseURL, "artist", &creators); err != nil {
+		return nil, err
+	}
+	return creators, nil
+}
+
+// FavoritePosts returns the single posts favorited by the logged-in account.
+// A session cookie has to be set on the manager.
+func (c *Manager) FavoritePosts(ctx context.Context, baseURL string) ([]FavoritePost, error) {
+	var posts []FavoritePost
+	if err := c.getFavorites(ctx, baseURL, "post", &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (c *Manager) getFavorites(ctx context.Context, baseURL, favoriteType string, v interface{}) error {
+	url := fmt.Sprintf("%s/api/v1/account/favorites?type=%s", strings.TrimSuffix(baseURL, "/"), favoriteType)
+	res, err := c.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkChallengeResponse(res); err != nil {
+		return err
+	}
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return &ChallengeError{URL: url, Reason: "login required"}
+	case res.StatusCode == http.StatusFound:
+		return &ChallengeError{URL: url, Reason: "redirected to " + res.Header.Get("Location")}
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+	if strings.Contains(res.Header.Get("Content-Type"), "text/html") {
+		return &ChallengeError{URL: url, Reason: "html returned instead of json"}
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
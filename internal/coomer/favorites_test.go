@@ -0,0 +1,148 @@
+package coomer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// favoritesAPI mocks the favorites API of a site. Requests without the
+// session cookie "good" are answered with 401.
+func favoritesAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/account/favorites" {
+			http.NotFound(w, r)
+			return
+		}
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("type") {
+		case "artist":
+			w.Write([]byte(`[{"id":"alice","name":"Alice","service":"onlyfans","faved_seq":1},{"id":"123","name":"Bob","service":"patreon"}]`))
+		case "post":
+			w.Write([]byte(`[{"id":"42","user":"alice","service":"onlyfans","title":"Beach"}]`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestManager(t *testing.T, session, siteURL string) *Manager {
+	t.Helper()
+	m, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session != "" {
+		m.SetSession(session, siteURL)
+	}
+	return m
+}
+
+func TestFavoriteCreators(t *testing.T) {
+	server := favoritesAPI(t)
+	m := newTestManager(t, "session=good", server.URL)
+
+	creators, err := m.FavoriteCreators(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FavoriteCreator{
+		{ID: "alice", Name: "Alice", Service: "onlyfans"},
+		{ID: "123", Name: "Bob", Service: "patreon"},
+	}
+	if len(creators) != len(want) {
+		t.Fatalf("got %d creators, want %d", len(creators), len(want))
+	}
+	for i := range want {
+		if creators[i] != want[i] {
+			t.Errorf("creator %d is %+v, want %+v", i, creators[i], want[i])
+		}
+	}
+	if got, want := creators[0].URL(server.URL+"/"), server.URL+"/onlyfans/user/alice"; got != want {
+		t.Errorf("creator URL is %s, want %s", got, want)
+	}
+}
+
+func TestFavoritePosts(t *testing.T) {
+	server := favoritesAPI(t)
+	m := newTestManager(t, "good", server.URL)
+
+	posts, err := m.FavoritePosts(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FavoritePost{ID: "42", User: "alice", Service: "onlyfans", Title: "Beach"}
+	if len(posts) != 1 || posts[0] != want {
+		t.Fatalf("got %+v, want [%+v]", posts, want)
+	}
+	if got, want := posts[0].URL(server.URL), server.URL+"/onlyfans/user/alice/post/42"; got != want {
+		t.Errorf("post URL is %s, want %s", got, want)
+	}
+}
+
+func TestFavoritesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		session string
+		handler http.HandlerFunc
+	}{
+		{
+			name:    "no session",
+			session: "",
+		},
+		{
+			name:    "wrong session",
+			session: "bad",
+		},
+		{
+			name:    "ddos-guard",
+			session: "good",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Server", "ddos-guard")
+				w.WriteHeader(http.StatusForbidden)
+			},
+		},
+		{
+			name:    "html instead of json",
+			session: "good",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write([]byte("<html><title>Just a moment...</title></html>"))
+			},
+		},
+		{
+			name:    "login redirect",
+			session: "good",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/account/login", http.StatusFound)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server *httptest.Server
+			if tt.handler != nil {
+				server = httptest.NewServer(tt.handler)
+				t.Cleanup(server.Close)
+			} else {
+				server = favoritesAPI(t)
+			}
+			m := newTestManager(t, tt.session, server.URL)
+
+			_, err := m.FavoriteCreators(context.Background(), server.URL)
+			var challengeErr *ChallengeError
+			if !errors.As(err, &challengeErr) {
+				t.Fatalf("got %v, want a *ChallengeError", err)
+			}
+		})
+	}
+}
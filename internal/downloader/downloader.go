@@ -1,14 +1,24 @@
 package downloader
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"party-dl/internal/fingerprint"
 	"party-dl/internal/metadata"
+	"party-dl/internal/progress"
+	"party-dl/internal/ratelimit"
+	"party-dl/internal/utils"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 )
@@ -16,24 +26,39 @@ import (
 type Downloader struct {
 	BaseDir string
 	Creator metadata.CreatorInfo
+	Client  *http.Client
+	// Bandwidth, if set, throttles the file bodies read by this downloader.
+	Bandwidth *ratelimit.Bandwidth
+	// Progress, if set, shows the running downloads.
+	Progress *progress.Tracker
+
+	transferred atomic.Int64
 }
 
 func NewDownloader(baseDir string, creator metadata.CreatorInfo) *Downloader {
 	return &Downloader{
 		BaseDir: baseDir,
 		Creator: creator,
+		Client:  http.DefaultClient,
 	}
 }
 
-func (d *Downloader) DownloadURL(url, description string, published time.Time) (string, bool, error) {
+func (d *Downloader) DownloadURL(ctx context.Context, url string, post metadata.Post) (string, bool, error) {
 	createDirectories(d.BaseDir)
 
-	dir := getDirectoryForExtension(filepath.Ext(url))
+	dir := getDirectoryForExtension(utils.GetExtension(url))
 
 	dirPath := filepath.Join(d.BaseDir, dir)
 	createDirectory(dirPath)
 
-	fileName := generateUniqueFileName(filepath.Ext(url))
+	fileName := generateUniqueFileName(utils.GetExtension(url))
+
+	// The part file is named after the URL so an interrupted download is
+	// resumed by the next run. Workers downloading the same URL take turns,
+	// the later ones find it in the metadata.
+	partFilePath := filepath.Join(dirPath, partFileName(url))
+	unlock := partFiles.lock(partFilePath)
+	defer unlock()
 
 	if exists, err := metadata.URLExistsInMetadata(filepath.Join(d.BaseDir, "metadata.json"), url); err != nil {
 		return "", false, err
@@ -41,9 +66,10 @@ func (d *Downloader) DownloadURL(url, description string, published time.Time) (
 		return "", true, nil
 	}
 
-	partFilePath := filepath.Join(dirPath, fileName+".part")
-	if err := downloadFile(url, partFilePath); err != nil {
-		os.Remove(partFilePath)
+	if err := d.downloadFile(ctx, url, partFilePath); err != nil {
+		if ctx.Err() == nil {
+			os.Remove(partFilePath)
+		}
 		return "", false, err
 	}
 
@@ -60,12 +86,24 @@ func (d *Downloader) DownloadURL(url, description string, published time.Time) (
 	}
 	size := fileInfo.Size()
 
+	sums, err := fingerprint.File(filePath)
+	if err != nil {
+		return "", false, err
+	}
+
 	fileInfoStruct := metadata.FileInfo{
 		FileName:    fileName,
 		Size:        size,
-		Description: description,
-		Published:   published,
+		Description: post.Description,
+		Published:   post.Published,
 		DownloadURL: url,
+		PostURL:     post.URL,
+		PostID:      post.ID,
+		Title:       post.Title,
+		Tags:        post.Tags,
+		SHA256:      sums.SHA256,
+		MD5:         sums.MD5,
+		OSHash:      sums.OSHash,
 	}
 
 	metadataFilePath := filepath.Join(d.BaseDir, "metadata.json")
@@ -76,30 +114,234 @@ func (d *Downloader) DownloadURL(url, description string, published time.Time) (
 	return filePath, false, nil
 }
 
-func downloadFile(url, filePath string) error {
-	out, err := os.Create(filePath)
+// Probe describes a file that would be downloaded.
+type Probe struct {
+	URL       string
+	MediaType string
+	// Size is the file's Content-Length, -1 if the server didn't send one.
+	Size   int64
+	Exists bool
+}
+
+// ProbeURL checks whether url has already been downloaded and, if not, asks
+// the server for its size without downloading it.
+func (d *Downloader) ProbeURL(ctx context.Context, url string) (Probe, error) {
+	probe := Probe{URL: url, MediaType: MediaType(url), Size: -1}
+
+	exists, err := metadata.URLExistsInMetadata(filepath.Join(d.BaseDir, "metadata.json"), url)
 	if err != nil {
-		return err
+		return probe, err
+	}
+	if exists {
+		probe.Exists = true
+		return probe, nil
 	}
-	defer out.Close()
 
-	response, err := http.Get(url)
+	size, err := d.ContentLength(ctx, url)
 	if err != nil {
-		return err
+		return probe, err
+	}
+	probe.Size = size
+	return probe, nil
+}
+
+// ContentLength returns the size the server reports for url in a HEAD
+// request, -1 if it is unknown.
+func (d *Downloader) ContentLength(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return -1, err
+	}
+	response, err := d.Client.Do(req)
+	if err != nil {
+		return -1, err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download: %s", response.Status)
+		return -1, fmt.Errorf("failed to get size of %s: %s", url, response.Status)
+	}
+	return response.ContentLength, nil
+}
+
+// MediaType returns the directory url is downloaded to: images, videos or
+// other.
+func MediaType(url string) string {
+	return getDirectoryForExtension(utils.GetExtension(url))
+}
+
+// downloadFile downloads url to filePath, continuing a partial file left by
+// an earlier run if the server supports range requests.
+func (d *Downloader) downloadFile(ctx context.Context, url, filePath string) (err error) {
+	task := d.Progress.Task(path.Base(url), -1)
+	defer func() { task.Done(err) }()
+
+	var offset int64
+	if stat, err := os.Stat(filePath); err == nil && stat.Size() > 0 {
+		offset = stat.Size()
+	}
+
+	var response *http.Response
+	flags := os.O_WRONLY | os.O_CREATE
+	for {
+		response, err = d.get(ctx, url, offset)
+		if err != nil {
+			return err
+		}
+		valid := true
+		switch response.StatusCode {
+		case http.StatusOK:
+			offset = 0
+			flags |= os.O_TRUNC
+		case http.StatusPartialContent:
+			start, _, err := parseContentRange(response.Header.Get("Content-Range"))
+			valid = err == nil && start == offset
+			flags |= os.O_APPEND
+		case http.StatusRequestedRangeNotSatisfiable:
+			// The part file holds the whole file only if it is as large as
+			// the file on the server.
+			_, total, err := parseContentRange(response.Header.Get("Content-Range"))
+			if err == nil && total == offset {
+				response.Body.Close()
+				return nil
+			}
+			valid = false
+		default:
+			response.Body.Close()
+			return fmt.Errorf("failed to download: %s", response.Status)
+		}
+		if valid {
+			break
+		}
+		response.Body.Close()
+		if offset == 0 {
+			return fmt.Errorf("failed to download: %s with an invalid Content-Range %q", response.Status, response.Header.Get("Content-Range"))
+		}
+		// The part file doesn't match what the server would send, start over.
+		offset = 0
+		flags = os.O_WRONLY | os.O_CREATE
 	}
+	defer response.Body.Close()
 
-	_, err = io.Copy(out, response.Body)
+	out, err := os.OpenFile(filePath, flags, 0644)
 	if err != nil {
-		os.Remove(filePath)
 		return err
 	}
+	defer out.Close()
+
+	if response.ContentLength >= 0 {
+		task.SetSize(offset + response.ContentLength)
+	}
+	task.Resume(offset)
 
-	return nil
+	body := task.Reader(response.Body)
+	if d.Bandwidth != nil {
+		body = d.Bandwidth.Reader(ctx, body)
+	}
+
+	written, err := io.Copy(out, body)
+	d.transferred.Add(written)
+	return err
+}
+
+// get requests url, from offset on if it isn't 0.
+func (d *Downloader) get(ctx context.Context, url string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return d.Client.Do(req)
+}
+
+// parseContentRange parses a Content-Range header such as "bytes 100-199/1000"
+// or "bytes */1000". start is -1 for an unsatisfied range, total is -1 if the
+// server doesn't know it.
+func parseContentRange(header string) (start, total int64, err error) {
+	rangeSpec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	byteRange, size, ok := strings.Cut(rangeSpec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil || total < 0 {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+		}
+	}
+	if byteRange == "*" {
+		return -1, total, nil
+	}
+	first, last, ok := strings.Cut(byteRange, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start || (total >= 0 && end >= total) {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return start, total, nil
+}
+
+// Transferred returns the bytes downloaded so far, not counting the parts of
+// resumed files downloaded by earlier runs.
+func (d *Downloader) Transferred() int64 {
+	return d.transferred.Load()
+}
+
+// partFileName returns the name of the part file url is downloaded to.
+func partFileName(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return hex.EncodeToString(sum[:]) + utils.GetExtension(url) + ".part"
+}
+
+// partFiles serializes the downloads to the same part file within the
+// process.
+var partFiles = keyedMutex{locks: make(map[string]*keyedLock)}
+
+// keyedMutex is a set of mutexes created on demand and dropped once nobody
+// holds or waits for them.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks key and returns the function unlocking it.
+func (m *keyedMutex) lock(key string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
 }
 
 func createDirectories(baseDir string) {
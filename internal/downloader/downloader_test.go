@@ -0,0 +1,221 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"party-dl/internal/metadata"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  int64
+		total  int64
+		err    bool
+	}{
+		{header: "bytes 100-199/1000", start: 100, total: 1000},
+		{header: "bytes 0-0/1", start: 0, total: 1},
+		{header: "bytes 100-199/*", start: 100, total: -1},
+		{header: "bytes */1000", start: -1, total: 1000},
+		{header: "", err: true},
+		{header: "bytes 100-199", err: true},
+		{header: "bytes 200-100/1000", err: true},
+		{header: "bytes 100-1000/1000", err: true},
+		{header: "bytes x-199/1000", err: true},
+		{header: "items 0-1/2", err: true},
+	}
+	for _, tt := range tests {
+		start, total, err := parseContentRange(tt.header)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: got no error", tt.header)
+			}
+			continue
+		}
+		if err != nil || start != tt.start || total != tt.total {
+			t.Errorf("%q: got %d, %d, %v, want %d, %d", tt.header, start, total, err, tt.start, tt.total)
+		}
+	}
+}
+
+// rangeServer serves content, answering range requests with respond.
+func rangeServer(t *testing.T, content []byte, respond func(w http.ResponseWriter, offset int64)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spec := r.Header.Get("Range")
+		if spec == "" {
+			w.Write(content)
+			return
+		}
+		offset, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(spec, "bytes="), "-"), 10, 64)
+		if err != nil {
+			t.Errorf("invalid Range %q", spec)
+		}
+		respond(w, offset)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	total := int64(len(content))
+
+	tests := []struct {
+		name    string
+		part    []byte
+		respond func(w http.ResponseWriter, offset int64)
+	}{
+		{
+			name: "honoured range",
+			part: content[:300],
+			respond: func(w http.ResponseWriter, offset int64) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, total-1, total))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[offset:])
+			},
+		},
+		{
+			name: "range at another offset",
+			part: content[:300],
+			respond: func(w http.ResponseWriter, offset int64) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", 100, total-1, total))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[100:])
+			},
+		},
+		{
+			name: "range without Content-Range",
+			part: content[:300],
+			respond: func(w http.ResponseWriter, offset int64) {
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[offset:])
+			},
+		},
+		{
+			name: "complete part file",
+			part: content,
+			respond: func(w http.ResponseWriter, offset int64) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+		},
+		{
+			name: "part file larger than the file",
+			part: append(append([]byte{}, content...), "garbage"...),
+			respond: func(w http.ResponseWriter, offset int64) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+		},
+		{
+			name: "unsatisfiable without total",
+			part: content[:300],
+			respond: func(w http.ResponseWriter, offset int64) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := rangeServer(t, content, tt.respond)
+			partFile := filepath.Join(t.TempDir(), "file.part")
+			if err := os.WriteFile(partFile, tt.part, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			d := NewDownloader(t.TempDir(), metadata.CreatorInfo{Name: "alice"})
+			if err := d.downloadFile(context.Background(), server.URL+"/file.mp4", partFile); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(partFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("part file has %d bytes, want the %d bytes of the file", len(got), len(content))
+			}
+		})
+	}
+}
+
+func TestDownloadURLConcurrentSameURL(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10000))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Send the file slowly, so the downloads overlap.
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		for i := 0; i < len(content); i += len(content) / 10 {
+			w.Write(content[i : i+len(content)/10])
+			w.(http.Flusher).Flush()
+			time.Sleep(2 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	baseDir := t.TempDir()
+	d := NewDownloader(baseDir, metadata.CreatorInfo{Name: "alice"})
+	url := server.URL + "/data/a.mp4"
+
+	const workers = 4
+	var wg sync.WaitGroup
+	paths := make([]string, workers)
+	existed := make([]bool, workers)
+	errs := make([]error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i], existed[i], errs[i] = d.DownloadURL(context.Background(), url, metadata.Post{URL: server.URL + "/post/1"})
+		}(i)
+	}
+	wg.Wait()
+
+	downloaded := 0
+	for i := 0; i < workers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("worker %d: %v", i, errs[i])
+		}
+		if existed[i] {
+			continue
+		}
+		downloaded++
+		got, err := os.ReadFile(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("downloaded file has %d bytes, want %d", len(got), len(content))
+		}
+	}
+	if downloaded != 1 {
+		t.Errorf("%d workers downloaded the file, want 1", downloaded)
+	}
+
+	meta, err := metadata.ReadMetadata(filepath.Join(baseDir, "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Files) != 1 || meta.Files[0].Size != int64(len(content)) {
+		t.Errorf("metadata has %+v, want one file of %d bytes", meta.Files, len(content))
+	}
+	parts, err := filepath.Glob(filepath.Join(baseDir, "videos", "*.part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 0 {
+		t.Errorf("part files left behind: %v", parts)
+	}
+	if len(partFiles.locks) != 0 {
+		t.Errorf("%d part file locks left behind", len(partFiles.locks))
+	}
+}
@@ -0,0 +1,191 @@
+package filter
+
+import (
+	"fmt"
+	"party-dl/internal/downloader"
+	"party-dl/internal/utils"
+	"regexp"
+	"strings"
+)
+
+// Files decides which files of a post are downloaded.
+type Files struct {
+	// Only limits the files to images, videos or attachments, which are all
+	// files that are neither images nor videos.
+	Only       string
+	IncludeExt []string
+	ExcludeExt []string
+	MinSize    int64
+	// MaxSize is the largest size downloaded, zero means no limit.
+	MaxSize int64
+}
+
+// NewFiles returns a file filter from the command line values. Sizes are
+// parsed with utils.ParseSize, empty values disable the filter.
+func NewFiles(only string, includeExt, excludeExt []string, minSize, maxSize string) (Files, error) {
+	files := Files{
+		Only:       strings.ToLower(only),
+		IncludeExt: normalizeExtensions(includeExt),
+		ExcludeExt: normalizeExtensions(excludeExt),
+	}
+	switch files.Only {
+	case "", "images", "videos", "attachments":
+	default:
+		return Files{}, fmt.Errorf("invalid --only value %q, expected images, videos or attachments", only)
+	}
+
+	var err error
+	if minSize != "" {
+		if files.MinSize, err = utils.ParseSize(minSize); err != nil {
+			return Files{}, err
+		}
+	}
+	if maxSize != "" {
+		if files.MaxSize, err = utils.ParseSize(maxSize); err != nil {
+			return Files{}, err
+		}
+	}
+	if files.MaxSize > 0 && files.MinSize > files.MaxSize {
+		return Files{}, fmt.Errorf("--min-size %s is larger than --max-size %s", minSize, maxSize)
+	}
+	return files, nil
+}
+
+// MatchURL reports whether the file at url passes the media type and
+// extension filters.
+func (f Files) MatchURL(url string) bool {
+	switch f.Only {
+	case "images", "videos":
+		if downloader.MediaType(url) != f.Only {
+			return false
+		}
+	case "attachments":
+		if downloader.MediaType(url) != "other" {
+			return false
+		}
+	}
+
+	ext := utils.GetExtension(url)
+	if len(f.IncludeExt) > 0 && !contains(f.IncludeExt, ext) {
+		return false
+	}
+	return !contains(f.ExcludeExt, ext)
+}
+
+// NeedsSize reports whether the file size is needed before downloading.
+func (f Files) NeedsSize() bool {
+	return f.MinSize > 0 || f.MaxSize > 0
+}
+
+// MatchSize reports whether a file of size bytes passes the size filters.
+// Files of unknown size (-1) pass.
+func (f Files) MatchSize(size int64) bool {
+	if size < 0 {
+		return true
+	}
+	if size < f.MinSize {
+		return false
+	}
+	return f.MaxSize == 0 || size <= f.MaxSize
+}
+
+func normalizeExtensions(extensions []string) []string {
+	normalized := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalized = append(normalized, ext)
+	}
+	return normalized
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Posts decides which posts are downloaded.
+type Posts struct {
+	// Match, if set, requires one of the expressions to match the post's
+	// title or description.
+	Match []*regexp.Regexp
+	// Exclude skips posts whose title or description matches any expression.
+	Exclude []*regexp.Regexp
+	// IDs, if set, only allows the listed post IDs.
+	IDs map[string]bool
+	// SkipIDs skips the listed post IDs.
+	SkipIDs map[string]bool
+}
+
+// NewPosts returns a post filter from the command line values.
+func NewPosts(match, exclude, ids, skipIDs []string) (Posts, error) {
+	var posts Posts
+	var err error
+	if posts.Match, err = compileAll(match); err != nil {
+		return Posts{}, err
+	}
+	if posts.Exclude, err = compileAll(exclude); err != nil {
+		return Posts{}, err
+	}
+	posts.IDs = toSet(ids)
+	posts.SkipIDs = toSet(skipIDs)
+	return posts, nil
+}
+
+// MatchID reports whether the post with id passes the ID lists.
+func (p Posts) MatchID(id string) bool {
+	if len(p.IDs) > 0 && !p.IDs[id] {
+		return false
+	}
+	return !p.SkipIDs[id]
+}
+
+// MatchContent reports whether a post with title and description passes the
+// expressions.
+func (p Posts) MatchContent(title, description string) bool {
+	for _, exclude := range p.Exclude {
+		if exclude.MatchString(title) || exclude.MatchString(description) {
+			return false
+		}
+	}
+	if len(p.Match) == 0 {
+		return true
+	}
+	for _, match := range p.Match {
+		if match.MatchString(title) || match.MatchString(description) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileAll(expressions []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(expressions))
+	for _, expression := range expressions {
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression %q: %w", expression, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			set[value] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,166 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewFiles(t *testing.T) {
+	tests := []struct {
+		name             string
+		only             string
+		include, exclude []string
+		minSize, maxSize string
+		want             Files
+		wantErr          bool
+	}{
+		{name: "empty", want: Files{IncludeExt: []string{}, ExcludeExt: []string{}}},
+		{
+			name:    "extensions",
+			only:    "Videos",
+			include: []string{"MP4", " .mkv", ""},
+			exclude: []string{"gif"},
+			want:    Files{Only: "videos", IncludeExt: []string{".mp4", ".mkv"}, ExcludeExt: []string{".gif"}},
+		},
+		{
+			name:    "sizes",
+			minSize: "10M",
+			maxSize: "4G",
+			want:    Files{IncludeExt: []string{}, ExcludeExt: []string{}, MinSize: 10 << 20, MaxSize: 4 << 30},
+		},
+		{
+			name:    "min size only",
+			minSize: "1K",
+			want:    Files{IncludeExt: []string{}, ExcludeExt: []string{}, MinSize: 1 << 10},
+		},
+		{name: "equal sizes", minSize: "1M", maxSize: "1M", want: Files{IncludeExt: []string{}, ExcludeExt: []string{}, MinSize: 1 << 20, MaxSize: 1 << 20}},
+		{name: "min larger than max", minSize: "2M", maxSize: "1M", wantErr: true},
+		{name: "invalid only", only: "audio", wantErr: true},
+		{name: "invalid size", minSize: "ten", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFiles(tt.only, tt.include, tt.exclude, tt.minSize, tt.maxSize)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewFiles() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewFiles() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilesMatchURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Files
+		url    string
+		want   bool
+	}{
+		{"no filter", Files{}, "https://n1.coomer.su/data/a.zip", true},
+		{"only images", Files{Only: "images"}, "https://n1.coomer.su/data/a.JPG", true},
+		{"only images, video", Files{Only: "images"}, "https://n1.coomer.su/data/a.mp4", false},
+		{"only videos", Files{Only: "videos"}, "https://n1.coomer.su/data/a.mkv", true},
+		{"only attachments", Files{Only: "attachments"}, "https://n1.coomer.su/data/a.zip", true},
+		{"only attachments, image", Files{Only: "attachments"}, "https://n1.coomer.su/data/a.png", false},
+		{"included", Files{IncludeExt: []string{".mp4"}}, "https://n1.coomer.su/data/a.mp4?f=b.mp4", true},
+		{"not included", Files{IncludeExt: []string{".mp4"}}, "https://n1.coomer.su/data/a.mov", false},
+		{"excluded", Files{ExcludeExt: []string{".gif"}}, "https://n1.coomer.su/data/a.gif", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.MatchURL(tt.url); got != tt.want {
+				t.Errorf("MatchURL(%q) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilesMatchSize(t *testing.T) {
+	filter := Files{MinSize: 100, MaxSize: 1000}
+	tests := []struct {
+		size int64
+		want bool
+	}{
+		{-1, true},
+		{0, false},
+		{99, false},
+		{100, true},
+		{1000, true},
+		{1001, false},
+	}
+	for _, tt := range tests {
+		if got := filter.MatchSize(tt.size); got != tt.want {
+			t.Errorf("MatchSize(%d) = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+	if !(Files{MinSize: 100}).MatchSize(1 << 40) {
+		t.Error("MatchSize without MaxSize rejected a large file")
+	}
+}
+
+func TestPosts(t *testing.T) {
+	posts, err := NewPosts([]string{"(?i)beach", "pool"}, []string{"(?i)ppv|dm me"}, []string{"1", " 2 ", ""}, []string{"2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idTests := []struct {
+		id   string
+		want bool
+	}{
+		{"1", true},
+		{"2", false},
+		{"3", false},
+	}
+	for _, tt := range idTests {
+		if got := posts.MatchID(tt.id); got != tt.want {
+			t.Errorf("MatchID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+
+	contentTests := []struct {
+		title, description string
+		want               bool
+	}{
+		{"Beach day", "", true},
+		{"", "at the pool", true},
+		{"Beach day", "DM me for more", false},
+		{"PPV beach", "", false},
+		{"Gym", "workout", false},
+	}
+	for _, tt := range contentTests {
+		if got := posts.MatchContent(tt.title, tt.description); got != tt.want {
+			t.Errorf("MatchContent(%q, %q) = %v, want %v", tt.title, tt.description, got, tt.want)
+		}
+	}
+}
+
+func TestPostsEmpty(t *testing.T) {
+	posts, err := NewPosts(nil, nil, nil, []string{"9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !posts.MatchID("1") || posts.MatchID("9") {
+		t.Error("MatchID without IDs only skips the skipped IDs")
+	}
+	if !posts.MatchContent("anything", "") {
+		t.Error("MatchContent without expressions rejected a post")
+	}
+}
+
+func TestNewPostsInvalidExpression(t *testing.T) {
+	if _, err := NewPosts([]string{"("}, nil, nil, nil); err == nil {
+		t.Error("NewPosts accepted an invalid match expression")
+	}
+	if _, err := NewPosts(nil, []string{"[a"}, nil, nil); err == nil {
+		t.Error("NewPosts accepted an invalid exclude expression")
+	}
+}
@@ -0,0 +1,75 @@
+package fingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// oshashChunkSize is the size of the head and tail of a file hashed by
+// oshash.
+const oshashChunkSize = 64 * 1024
+
+// Sums are the fingerprints of a file. Stash identifies scenes by oshash and
+// images by MD5, SHA-256 is kept to check files against.
+type Sums struct {
+	SHA256 string
+	MD5    string
+	OSHash string
+}
+
+// File computes the fingerprints of the file at path.
+func File(path string) (Sums, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Sums{}, err
+	}
+	defer file.Close()
+
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(sha256Hash, md5Hash), file)
+	if err != nil {
+		return Sums{}, err
+	}
+	osHash, err := OSHash(file, size)
+	if err != nil {
+		return Sums{}, err
+	}
+
+	return Sums{
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		MD5:    hex.EncodeToString(md5Hash.Sum(nil)),
+		OSHash: osHash,
+	}, nil
+}
+
+// OSHash computes the OpenSubtitles hash Stash uses for scenes: the file size
+// plus the sum of the little-endian 64 bit words of its first and last 64KiB.
+func OSHash(r io.ReaderAt, size int64) (string, error) {
+	if size == 0 {
+		return "", nil
+	}
+	chunkSize := int64(oshashChunkSize)
+	if size < chunkSize {
+		chunkSize = size
+	}
+
+	buf := make([]byte, 2*chunkSize)
+	if _, err := r.ReadAt(buf[:chunkSize], 0); err != nil && err != io.EOF {
+		return "", err
+	}
+	if _, err := r.ReadAt(buf[chunkSize:], size-chunkSize); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	sum := uint64(size)
+	for i := 0; i+8 <= len(buf); i += 8 {
+		sum += binary.LittleEndian.Uint64(buf[i:])
+	}
+	return fmt.Sprintf("%016x", sum), nil
+}
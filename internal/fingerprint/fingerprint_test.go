@@ -0,0 +1,63 @@
+package fingerprint
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testData returns n bytes of a fixed pattern. The expected hashes below
+// were computed from the same pattern with an independent implementation.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte((i*31 + 7) % 251)
+	}
+	return data
+}
+
+func TestOSHash(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want string
+	}{
+		{"empty", 0, ""},
+		{"one word", 8, "c1834506c88a4c16"},
+		{"smaller than a chunk", 1024, "ec7d0fa9ec245e04"},
+		{"one chunk", 64 * 1024, "9e1888f771e33164"},
+		{"overlapping head and tail", 100000, "27a2127a178862ad"},
+		{"larger than two chunks", 3*64*1024 + 8, "a334ae22980a5c93"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OSHash(bytes.NewReader(testData(tt.size)), int64(tt.size))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("OSHash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.mp4")
+	if err := os.WriteFile(path, testData(1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := File(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Sums{
+		SHA256: "8dcdcf24d5ee9e222bebf46f1b93b5b923970230faccd3ce268adfd31e3ef19f",
+		MD5:    "5121b74d11d0ad611a246b4137993844",
+		OSHash: "ec7d0fa9ec245e04",
+	}
+	if got != want {
+		t.Errorf("File() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	FileName = ".party-dl.lock"
+	// guardFileName is locked with flock or LockFileEx while a run checks
+	// the lock and replaces a stale one, so two runs can't both take over
+	// the same stale lock. It is never removed.
+	guardFileName = ".party-dl.lock.guard"
+
+	// heartbeatInterval is how often a held lock's modification time is
+	// refreshed. A lock not refreshed for staleAfter is considered stale, so
+	// locks of crashed runs on other hosts expire.
+	heartbeatInterval = time.Minute
+	staleAfter        = 5 * time.Minute
+	pollInterval      = 2 * time.Second
+)
+
+// beforeTakeover is called before a stale lock is removed, tests use it to
+// widen the window in which runs race for the stale lock.
+var beforeTakeover = func() {}
+
+// Info describes the holder of a lock.
+type Info struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// LockedError is returned when a directory is locked by another run.
+type LockedError struct {
+	Path   string
+	Holder Info
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is locked by %q (pid %d on %s) since %s, use --wait-lock to wait for it",
+		filepath.Dir(e.Path), e.Holder.Command, e.Holder.PID, e.Holder.Host, e.Holder.Started.Format(time.RFC3339))
+}
+
+// Lock is a held lock on a directory.
+type Lock struct {
+	path string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Acquire locks dir for command, creating dir if needed. A lock held by
+// another run makes it return a *LockedError, or with wait, poll until the
+// lock is released or ctx is done. Stale locks of dead runs are taken over.
+func Acquire(ctx context.Context, dir, command string, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, FileName)
+	guardPath := filepath.Join(dir, guardFileName)
+
+	for {
+		holder, acquired, err := tryAcquire(path, guardPath, command)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			l := &Lock{path: path, stop: make(chan struct{}), done: make(chan struct{})}
+			go l.heartbeat()
+			return l, nil
+		}
+		if !wait {
+			return nil, &LockedError{Path: path, Holder: holder}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// tryAcquire creates the lock at path, replacing a stale one, while holding
+// the guard at guardPath. If the lock is held by another run, its holder is
+// returned.
+func tryAcquire(path, guardPath, command string) (Info, bool, error) {
+	guard, err := os.OpenFile(guardPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return Info{}, false, err
+	}
+	defer guard.Close()
+	if err := lockFile(guard); err != nil {
+		return Info{}, false, err
+	}
+
+	for {
+		err := create(path, command)
+		if err == nil {
+			return Info{}, true, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return Info{}, false, err
+		}
+
+		holder, stale, err := inspect(path)
+		if err != nil {
+			return Info{}, false, err
+		}
+		if !stale {
+			return holder, false, nil
+		}
+		// Nobody else replaces the stale lock while the guard is held.
+		beforeTakeover()
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return Info{}, false, err
+		}
+	}
+}
+
+// Release unlocks the directory.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+	return os.Remove(l.path)
+}
+
+func (l *Lock) heartbeat() {
+	defer close(l.done)
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			os.Chtimes(l.path, now, now)
+		}
+	}
+}
+
+func create(path, command string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	info := Info{PID: os.Getpid(), Host: host, Command: command, Started: time.Now()}
+	if err := json.NewEncoder(file).Encode(info); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// inspect reads the lock at path and reports whether it is stale: its holder
+// runs on this host and is gone, or it hasn't been refreshed for staleAfter.
+func inspect(path string) (Info, bool, error) {
+	var info Info
+	stat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Released in the meantime, the caller retries.
+			return info, true, nil
+		}
+		return info, false, err
+	}
+	if time.Since(stat.ModTime()) > staleAfter {
+		return info, true, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return info, false, err
+	}
+	if err := json.Unmarshal(content, &info); err != nil {
+		// Still being written by its holder.
+		return info, false, nil
+	}
+	host, _ := os.Hostname()
+	if strings.EqualFold(info.Host, host) && !processAlive(info.PID) {
+		return info, true, nil
+	}
+	return info, false, nil
+}
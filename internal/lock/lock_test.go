@@ -0,0 +1,107 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeStaleLock(t *testing.T, dir string) {
+	t.Helper()
+	path := filepath.Join(dir, FileName)
+	content, err := json.Marshal(Info{PID: 1, Host: "other-host", Command: "crashed", Started: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * staleAfter)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLocked(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Acquire(context.Background(), dir, "first", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+
+	_, err = Acquire(context.Background(), dir, "second", false)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("got %v, want a *LockedError", err)
+	}
+	if locked.Holder.Command != "first" {
+		t.Errorf("holder is %q, want first", locked.Holder.Command)
+	}
+}
+
+func TestAcquireTakesOverStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	writeStaleLock(t, dir)
+
+	l, err := Acquire(context.Background(), dir, "new", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, FileName)); !os.IsNotExist(err) {
+		t.Errorf("lock file still exists after release: %v", err)
+	}
+}
+
+func TestAcquireConcurrentStaleTakeover(t *testing.T) {
+	beforeTakeover = func() { time.Sleep(10 * time.Millisecond) }
+	defer func() { beforeTakeover = func() {} }()
+
+	const runs = 8
+	for round := 0; round < 5; round++ {
+		dir := t.TempDir()
+		writeStaleLock(t, dir)
+
+		var (
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			held   []*Lock
+			locked int
+		)
+		start := make(chan struct{})
+		for i := 0; i < runs; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				l, err := Acquire(context.Background(), dir, "run", false)
+				mu.Lock()
+				defer mu.Unlock()
+				var lockedErr *LockedError
+				switch {
+				case err == nil:
+					held = append(held, l)
+				case errors.As(err, &lockedErr):
+					locked++
+				default:
+					t.Error(err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if len(held) != 1 || locked != runs-1 {
+			t.Fatalf("round %d: %d runs hold the lock and %d were locked out, want 1 and %d", round, len(held), locked, runs-1)
+		}
+		held[0].Release()
+	}
+}
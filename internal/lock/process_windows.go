@@ -0,0 +1,14 @@
+//go:build windows
+
+package lock
+
+import "os"
+
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
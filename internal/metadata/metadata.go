@@ -2,10 +2,17 @@ package metadata
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
+// writeMutex serializes metadata writes of concurrent download workers.
+var writeMutex sync.Mutex
+
 type CreatorInfo struct {
 	Name     string `json:"name"`
 	Service  string `json:"service"`
@@ -23,38 +30,83 @@ type FileInfo struct {
 	Description string    `json:"description"`
 	DownloadURL string    `json:"downloadURL"`
 	Published   time.Time `json:"published"`
+	// The post the file belongs to, not recorded by older versions.
+	PostURL string   `json:"postURL,omitempty"`
+	PostID  string   `json:"postID,omitempty"`
+	Title   string   `json:"title,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	SHA256  string   `json:"sha256,omitempty"`
+	MD5     string   `json:"md5,omitempty"`
+	OSHash  string   `json:"oshash,omitempty"`
+}
+
+// Post is the post a file is downloaded from.
+type Post struct {
+	URL         string
+	ID          string
+	Title       string
+	Description string
+	Published   time.Time
+	Tags        []string
 }
 
+// AppendMetadata adds fileInfo to the metadata file at filePath. The file is
+// replaced atomically, so an interrupted run never leaves it half-written.
 func AppendMetadata(filePath string, fileInfo FileInfo, creator CreatorInfo) error {
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
 
-	var metadata Metadata
-	stat, err := file.Stat()
-	if err != nil {
+	metadata := &Metadata{}
+	if existing, err := ReadMetadata(filePath); err == nil {
+		metadata = existing
+	} else if !os.IsNotExist(err) && !errors.Is(err, io.EOF) {
 		return err
 	}
-	if stat.Size() != 0 {
-		if err := json.NewDecoder(file).Decode(&metadata); err != nil {
-			return err
-		}
-	}
 
 	metadata.Creator = creator
 
 	metadata.Files = append(metadata.Files, fileInfo)
 
-	if _, err := file.Seek(0, 0); err != nil {
+	return writeMetadata(filePath, metadata)
+}
+
+// UpdateMetadata applies update to the metadata file at filePath and writes
+// it back atomically.
+func UpdateMetadata(filePath string, update func(*Metadata)) error {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	metadata, err := ReadMetadata(filePath)
+	if err != nil {
 		return err
 	}
-	if err := json.NewEncoder(file).Encode(metadata); err != nil {
+	update(metadata)
+	return writeMetadata(filePath, metadata)
+}
+
+func writeMetadata(filePath string, metadata *Metadata) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
 		return err
 	}
+	defer os.Remove(tempFile.Name())
 
-	return nil
+	if err := tempFile.Chmod(0644); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := json.NewEncoder(tempFile).Encode(metadata); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), filePath)
 }
 
 func URLExistsInMetadata(metadataFilePath, url string) (bool, error) {
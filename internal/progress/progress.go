@@ -0,0 +1,345 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-isatty"
+	"github.com/muesli/reflow/truncate"
+	"golang.org/x/term"
+)
+
+const (
+	barWidth      = 30
+	nameWidth     = 36
+	renderEvery   = 200 * time.Millisecond
+	speedInterval = 3 * time.Second
+)
+
+// Tracker follows the running downloads and, on a terminal, redraws a bar per
+// download plus a summary line. It is also an io.Writer so log lines can be
+// printed above the bars without tearing them.
+//
+// All methods are safe on a nil *Tracker.
+type Tracker struct {
+	out         io.Writer
+	fd          int
+	interactive bool
+
+	mu          sync.Mutex
+	tasks       []*Task
+	queued      int
+	completed   int
+	failed      int
+	bytes       int64
+	doneBytes   int64
+	started     time.Time
+	samples     []sample
+	drawnLines  int
+	stop        chan struct{}
+	stopped     chan struct{}
+	barStyle    lipgloss.Style
+	emptyStyle  lipgloss.Style
+	dimStyle    lipgloss.Style
+	failedStyle lipgloss.Style
+}
+
+type sample struct {
+	at    time.Time
+	bytes int64
+}
+
+// Task is a single file download shown as one bar.
+type Task struct {
+	tracker *Tracker
+	name    string
+	size    int64
+	read    int64
+}
+
+// New returns a tracker drawing to out. Bars are only drawn when out is a
+// terminal, otherwise the tracker only counts.
+func New(out *os.File) *Tracker {
+	renderer := lipgloss.NewRenderer(out)
+	return &Tracker{
+		out:         out,
+		fd:          int(out.Fd()),
+		interactive: isatty.IsTerminal(out.Fd()) || isatty.IsCygwinTerminal(out.Fd()),
+		started:     time.Now(),
+		barStyle:    renderer.NewStyle().Foreground(lipgloss.Color("212")),
+		emptyStyle:  renderer.NewStyle().Foreground(lipgloss.Color("238")),
+		dimStyle:    renderer.NewStyle().Foreground(lipgloss.Color("245")),
+		failedStyle: renderer.NewStyle().Foreground(lipgloss.Color("196")),
+	}
+}
+
+// Interactive reports whether the tracker draws bars.
+func (t *Tracker) Interactive() bool {
+	return t != nil && t.interactive
+}
+
+// Start begins redrawing the bars until Stop is called.
+func (t *Tracker) Start() {
+	if !t.Interactive() {
+		return
+	}
+	t.stop = make(chan struct{})
+	t.stopped = make(chan struct{})
+	go func() {
+		defer close(t.stopped)
+		ticker := time.NewTicker(renderEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				t.mu.Lock()
+				t.render()
+				t.mu.Unlock()
+				return
+			case <-ticker.C:
+				t.mu.Lock()
+				t.render()
+				t.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop draws the final state and stops redrawing.
+func (t *Tracker) Stop() {
+	if !t.Interactive() || t.stop == nil {
+		return
+	}
+	close(t.stop)
+	<-t.stopped
+	t.stop = nil
+}
+
+// Write prints p above the bars.
+func (t *Tracker) Write(p []byte) (int, error) {
+	if t == nil {
+		return len(p), nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.interactive {
+		return t.out.Write(p)
+	}
+	t.clear()
+	n, err := t.out.Write(p)
+	t.drawnLines = 0
+	return n, err
+}
+
+// AddQueued changes the queue depth by n files.
+func (t *Tracker) AddQueued(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.queued += n
+	t.mu.Unlock()
+}
+
+// Task starts a bar for a download of size bytes, size may be unknown (-1).
+func (t *Tracker) Task(name string, size int64) *Task {
+	if t == nil {
+		return nil
+	}
+	task := &Task{tracker: t, name: name, size: size}
+	t.mu.Lock()
+	t.tasks = append(t.tasks, task)
+	t.mu.Unlock()
+	return task
+}
+
+// SetSize sets the task's total size once it is known, -1 if unknown.
+func (task *Task) SetSize(size int64) {
+	if task == nil {
+		return
+	}
+	task.tracker.mu.Lock()
+	task.size = size
+	task.tracker.mu.Unlock()
+}
+
+// Resume marks the first offset bytes as done without counting them as
+// transferred, for downloads continuing a partial file.
+func (task *Task) Resume(offset int64) {
+	if task == nil {
+		return
+	}
+	task.tracker.mu.Lock()
+	task.read = offset
+	task.tracker.mu.Unlock()
+}
+
+// Reader counts the bytes read from r towards the task.
+func (task *Task) Reader(r io.Reader) io.Reader {
+	if task == nil {
+		return r
+	}
+	return &taskReader{task: task, r: r}
+}
+
+// Done removes the task's bar and counts it as completed or failed.
+func (task *Task) Done(err error) {
+	if task == nil {
+		return
+	}
+	t := task.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, running := range t.tasks {
+		if running == task {
+			t.tasks = append(t.tasks[:i], t.tasks[i+1:]...)
+			break
+		}
+	}
+	if err != nil {
+		t.failed++
+		return
+	}
+	t.completed++
+	t.doneBytes += task.read
+}
+
+// Failed counts a failure that happened outside of a task.
+func (t *Tracker) Failed() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.failed++
+	t.mu.Unlock()
+}
+
+type taskReader struct {
+	task *Task
+	r    io.Reader
+}
+
+func (tr *taskReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		t := tr.task.tracker
+		t.mu.Lock()
+		tr.task.read += int64(n)
+		t.bytes += int64(n)
+		t.mu.Unlock()
+	}
+	return n, err
+}
+
+// clear erases the previously drawn bars. Must be called with t.mu held.
+func (t *Tracker) clear() {
+	if t.drawnLines > 0 {
+		fmt.Fprintf(t.out, "\x1b[%dA\x1b[J", t.drawnLines)
+	}
+}
+
+// render redraws the bars. Must be called with t.mu held.
+func (t *Tracker) render() {
+	now := time.Now()
+	t.samples = append(t.samples, sample{at: now, bytes: t.bytes})
+	for len(t.samples) > 1 && now.Sub(t.samples[0].at) > speedInterval {
+		t.samples = t.samples[1:]
+	}
+	speed := float64(0)
+	if first := t.samples[0]; now.Sub(first.at) > 0 {
+		speed = float64(t.bytes-first.bytes) / now.Sub(first.at).Seconds()
+	}
+
+	var buf bytes.Buffer
+	// Lines wider than the terminal would wrap, and clear would then move
+	// up too few rows.
+	width := 0
+	if w, _, err := term.GetSize(t.fd); err == nil && w > 1 {
+		width = w - 1
+	}
+	line := func(s string) {
+		if width > 0 {
+			s = truncate.String(s, uint(width))
+		}
+		buf.WriteString(s)
+		buf.WriteByte('\n')
+	}
+
+	for _, task := range t.tasks {
+		line(t.renderTask(task))
+	}
+	line(t.renderSummary(speed))
+
+	t.clear()
+	t.out.Write(buf.Bytes())
+	t.drawnLines = len(t.tasks) + 1
+}
+
+func (t *Tracker) renderTask(task *Task) string {
+	name := task.name
+	if len(name) > nameWidth {
+		name = "…" + name[len(name)-nameWidth+1:]
+	}
+	name = fmt.Sprintf("%-*s", nameWidth, name)
+
+	if task.size <= 0 {
+		return fmt.Sprintf("%s %s %s", name, t.dimStyle.Render(strings.Repeat("·", barWidth)), FormatBytes(task.read))
+	}
+	filled := int(float64(barWidth) * float64(task.read) / float64(task.size))
+	if filled > barWidth {
+		filled = barWidth
+	}
+	bar := t.barStyle.Render(strings.Repeat("█", filled)) + t.emptyStyle.Render(strings.Repeat("░", barWidth-filled))
+	percent := 100 * float64(task.read) / float64(task.size)
+	return fmt.Sprintf("%s %s %3.0f%% %s", name, bar, percent, t.dimStyle.Render(FormatBytes(task.read)+"/"+FormatBytes(task.size)))
+}
+
+func (t *Tracker) renderSummary(speed float64) string {
+	eta := "--"
+	if remaining := t.remainingBytes(); remaining > 0 && speed > 0 {
+		eta = time.Duration(float64(remaining) / speed * float64(time.Second)).Round(time.Second).String()
+	}
+	failed := fmt.Sprintf("%d failed", t.failed)
+	if t.failed > 0 {
+		failed = t.failedStyle.Render(failed)
+	}
+	return fmt.Sprintf("%s total, %s/s, ETA %s | %d done, %d active, %d queued, %s | %s elapsed",
+		FormatBytes(t.bytes), FormatBytes(int64(speed)), eta,
+		t.completed, len(t.tasks), t.queued, failed,
+		time.Since(t.started).Round(time.Second))
+}
+
+// remainingBytes estimates the bytes left, counting queued files at the
+// average size of the completed ones.
+func (t *Tracker) remainingBytes() int64 {
+	var remaining int64
+	for _, task := range t.tasks {
+		if task.size > task.read {
+			remaining += task.size - task.read
+		}
+	}
+	if t.completed > 0 {
+		remaining += int64(t.queued) * (t.doneBytes / int64(t.completed))
+	}
+	return remaining
+}
+
+// FormatBytes formats n with a binary unit, e.g. 1.5 MB.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for value := n / unit; value >= unit; value /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
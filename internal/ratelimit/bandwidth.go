@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"party-dl/internal/utils"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// bandwidthBurst is the largest chunk a throttled reader reads at once.
+const bandwidthBurst = 32 * 1024
+
+// Bandwidth is a byte rate shared by every reader created from it. The limit
+// can be changed while readers are in use.
+type Bandwidth struct {
+	limiter *rate.Limiter
+}
+
+// NewBandwidth returns a bandwidth limit of bytesPerSecond, zero or less means
+// unlimited.
+func NewBandwidth(bytesPerSecond int64) *Bandwidth {
+	b := &Bandwidth{limiter: rate.NewLimiter(rate.Inf, bandwidthBurst)}
+	b.SetLimit(bytesPerSecond)
+	return b
+}
+
+func (b *Bandwidth) SetLimit(bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		b.limiter.SetLimit(rate.Inf)
+		return
+	}
+	b.limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+// Limit returns the current limit in bytes per second, zero if unlimited.
+func (b *Bandwidth) Limit() int64 {
+	limit := b.limiter.Limit()
+	if limit == rate.Inf {
+		return 0
+	}
+	return int64(limit)
+}
+
+// Reader wraps r so reads from it draw from the shared bandwidth.
+func (b *Bandwidth) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &throttledReader{ctx: ctx, r: r, bandwidth: b}
+}
+
+// Follow applies the schedule's rate every interval until ctx is done. Times
+// not covered by a window use fallback.
+func (b *Bandwidth) Follow(ctx context.Context, schedule Schedule, fallback int64, interval time.Duration) {
+	b.SetLimit(schedule.RateAt(time.Now(), fallback))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.SetLimit(schedule.RateAt(now, fallback))
+		}
+	}
+}
+
+type throttledReader struct {
+	ctx       context.Context
+	r         io.Reader
+	bandwidth *Bandwidth
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > bandwidthBurst {
+		p = p[:bandwidthBurst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.bandwidth.limiter.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Window is a daily time range with its own rate. A window whose end is
+// before its start wraps around midnight.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+	Rate  int64
+}
+
+type Schedule []Window
+
+// ParseSchedule parses comma separated windows such as
+// "01:00-07:00=0,18:00-23:30=500K". A rate of 0 means unlimited.
+func ParseSchedule(s string) (Schedule, error) {
+	var schedule Schedule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		span, rateValue, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule window %q, expected HH:MM-HH:MM=RATE", part)
+		}
+		startValue, endValue, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule window %q, expected HH:MM-HH:MM=RATE", part)
+		}
+		start, err := parseTimeOfDay(startValue)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(endValue)
+		if err != nil {
+			return nil, err
+		}
+		bytesPerSecond, err := utils.ParseSize(rateValue)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, Window{Start: start, End: end, Rate: bytesPerSecond})
+	}
+	return schedule, nil
+}
+
+// RateAt returns the rate of the first window containing t, or fallback.
+func (s Schedule) RateAt(t time.Time, fallback int64) int64 {
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	for _, window := range s {
+		if window.Start <= window.End {
+			if now >= window.Start && now < window.End {
+				return window.Rate
+			}
+		} else if now >= window.Start || now < window.End {
+			return window.Rate
+		}
+	}
+	return fallback
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
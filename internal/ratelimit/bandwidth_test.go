@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Schedule
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "01:00-07:00=0", want: Schedule{{Start: time.Hour, End: 7 * time.Hour, Rate: 0}}},
+		{
+			in: " 18:00-23:30=500K , 23:30-06:00=2M,",
+			want: Schedule{
+				{Start: 18 * time.Hour, End: 23*time.Hour + 30*time.Minute, Rate: 500 << 10},
+				{Start: 23*time.Hour + 30*time.Minute, End: 6 * time.Hour, Rate: 2 << 20},
+			},
+		},
+		{in: "01:00-07:00", wantErr: true},
+		{in: "01:00=1M", wantErr: true},
+		{in: "25:00-07:00=1M", wantErr: true},
+		{in: "01:00-7=1M", wantErr: true},
+		{in: "01:00-07:00=fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSchedule(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSchedule(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.in, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSchedule(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleRateAt(t *testing.T) {
+	schedule, err := ParseSchedule("01:00-07:00=0,22:00-02:00=1K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		clock string
+		want  int64
+	}{
+		{"00:30", 1 << 10},
+		{"01:30", 0},
+		{"06:59", 0},
+		{"07:00", 5},
+		{"12:00", 5},
+		{"22:00", 1 << 10},
+		{"23:59", 1 << 10},
+	}
+	for _, tt := range tests {
+		at, err := time.Parse("15:04", tt.clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := schedule.RateAt(at, 5); got != tt.want {
+			t.Errorf("RateAt(%s) = %d, want %d", tt.clock, got, tt.want)
+		}
+	}
+}
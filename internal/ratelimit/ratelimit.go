@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter is a set of token buckets, one per host. A host without an
+// override uses the default rate.
+type Limiter struct {
+	defaultRate float64
+	overrides   []override
+
+	mu    sync.Mutex
+	hosts map[string]*rate.Limiter
+}
+
+// override is the rate of a domain and its subdomains, or of its subdomains
+// only if wildcard is set.
+type override struct {
+	domain    string
+	wildcard  bool
+	perSecond float64
+}
+
+// New returns a limiter allowing perSecond requests per second to every host.
+// A rate of zero or less disables limiting. Overrides map a domain and all of
+// its subdomains, or with a "*." prefix only its subdomains, to its own rate.
+// If several overrides match a host, the longest domain wins, and a wildcard
+// wins over the plain domain.
+func New(perSecond float64, overrides map[string]float64) *Limiter {
+	var sorted []override
+	for host, rate := range overrides {
+		host = strings.ToLower(host)
+		domain, wildcard := strings.CutPrefix(host, "*.")
+		sorted = append(sorted, override{domain: domain, wildcard: wildcard, perSecond: rate})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].domain) != len(sorted[j].domain) {
+			return len(sorted[i].domain) > len(sorted[j].domain)
+		}
+		if sorted[i].wildcard != sorted[j].wildcard {
+			return sorted[i].wildcard
+		}
+		return sorted[i].domain < sorted[j].domain
+	})
+	return &Limiter{
+		defaultRate: perSecond,
+		overrides:   sorted,
+		hosts:       make(map[string]*rate.Limiter),
+	}
+}
+
+// Wait blocks until a request to host is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, host string) error {
+	return l.limiter(host).Wait(ctx)
+}
+
+func (l *Limiter) limiter(host string) *rate.Limiter {
+	host = strings.ToLower(host)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limiter, ok := l.hosts[host]; ok {
+		return limiter
+	}
+	limiter := newBucket(l.rateFor(host))
+	l.hosts[host] = limiter
+	return limiter
+}
+
+func (l *Limiter) rateFor(host string) float64 {
+	name := host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		name = hostname
+	}
+	for _, override := range l.overrides {
+		if (!override.wildcard && name == override.domain) || strings.HasSuffix(name, "."+override.domain) {
+			return override.perSecond
+		}
+	}
+	return l.defaultRate
+}
+
+func newBucket(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), int(math.Max(1, math.Ceil(perSecond))))
+}
+
+// Transport is an http.RoundTripper that waits for the request host's bucket
+// before every request.
+type Transport struct {
+	Base    http.RoundTripper
+	Limiter *Limiter
+}
+
+func NewTransport(limiter *Limiter) *Transport {
+	return &Transport{
+		Base:    http.DefaultTransport,
+		Limiter: limiter,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(req.Context(), req.URL.Host); err != nil {
+		return nil, err
+	}
+	return t.Base.RoundTrip(req)
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import "testing"
+
+func TestRateFor(t *testing.T) {
+	overrides := map[string]float64{
+		"coomer.su":       1,
+		"*.coomer.su":     2,
+		"n1.coomer.su":    3,
+		"Kemono.su":       4,
+		"*.img.kemono.su": 5,
+		"*.party":         6,
+	}
+	tests := []struct {
+		host string
+		want float64
+	}{
+		{"coomer.su", 1},
+		{"coomer.su:443", 1},
+		{"n2.coomer.su", 2},
+		{"a.b.coomer.su", 2},
+		{"n1.coomer.su", 3},
+		{"x.n1.coomer.su", 3},
+		{"kemono.su", 4},
+		{"n1.kemono.su", 4},
+		{"img.kemono.su", 4},
+		{"a.img.kemono.su", 5},
+		{"kemono.party", 6},
+		{"party", 10},
+		{"notcoomer.su", 10},
+		{"example.com", 10},
+	}
+	for i := 0; i < 20; i++ {
+		limiter := New(10, overrides)
+		for _, tt := range tests {
+			if got := limiter.rateFor(tt.host); got != tt.want {
+				t.Fatalf("rateFor(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		}
+	}
+}
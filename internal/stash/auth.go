@@ -0,0 +1,46 @@
+package stash
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AuthError is returned when stash rejects a request because the API key is
+// missing or wrong.
+type AuthError struct {
+	URL    string
+	Status string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("stash at %s rejected the request (%s), check the API key", e.URL, e.Status)
+}
+
+// authTransport sends the API key with every request and turns stash's
+// authentication failures into an *AuthError.
+type authTransport struct {
+	Base   http.RoundTripper
+	APIKey string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.APIKey != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("ApiKey", t.APIKey)
+	}
+	response, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without valid credentials stash answers 401, or redirects to its
+	// login page when it takes the request for a browser.
+	location := response.Header.Get("Location")
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden ||
+		(response.StatusCode >= 300 && response.StatusCode < 400 && strings.Contains(location, "/login")) {
+		response.Body.Close()
+		return nil, &AuthError{URL: req.URL.Scheme + "://" + req.URL.Host, Status: response.Status}
+	}
+	return response, nil
+}
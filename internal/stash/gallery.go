@@ -0,0 +1,75 @@
+package stash
+
+import (
+	"context"
+	"fmt"
+)
+
+// GalleryInput describes a gallery to create.
+type GalleryInput struct {
+	Title        string   `json:"title"`
+	URLs         []string `json:"urls,omitempty"`
+	Date         string   `json:"date,omitempty"`
+	Details      string   `json:"details,omitempty"`
+	Code         string   `json:"code,omitempty"`
+	StudioID     string   `json:"studio_id,omitempty"`
+	PerformerIDs []string `json:"performer_ids,omitempty"`
+	TagIDs       []string `json:"tag_ids,omitempty"`
+}
+
+// GetOrCreateGallery returns the ID of the gallery with the URL url, and
+// creates it from input, with url added to its URLs, if there is none.
+func (s *Manager) GetOrCreateGallery(ctx context.Context, url string, input GalleryInput) (string, error) {
+	findQuery := `
+		query FindGalleries($filter: FindFilterType, $gallery_filter: GalleryFilterType) {
+			findGalleries(filter: $filter, gallery_filter: $gallery_filter) {
+				count
+				galleries {
+					id
+				}
+			}
+		}
+	`
+
+	variables := map[string]interface{}{
+		"filter": map[string]interface{}{"per_page": 1},
+		"gallery_filter": map[string]interface{}{
+			"url": map[string]interface{}{"value": url, "modifier": "EQUALS"},
+		},
+	}
+	entityID, err := s.findEntity(ctx, findQuery, variables, "findGalleries", "galleries")
+	if err != nil {
+		return "", err
+	}
+
+	if entityID == "" {
+		input.URLs = append([]string{url}, input.URLs...)
+		return s.createEntity(ctx, "galleryCreate", "GalleryCreateInput", input)
+	}
+
+	return entityID, nil
+}
+
+// AddGalleryImages adds the images to the gallery. Images already in it are
+// left as they are.
+func (s *Manager) AddGalleryImages(ctx context.Context, galleryID string, imageIDs []string) error {
+	mutation := `
+		mutation AddGalleryImages($input: GalleryAddInput!) {
+			addGalleryImages(input: $input)
+		}
+	`
+
+	var response struct {
+		AddGalleryImages bool `json:"addGalleryImages"`
+	}
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{"gallery_id": galleryID, "image_ids": imageIDs},
+	}
+	if err := s.run(ctx, mutation, variables, &response); err != nil {
+		return err
+	}
+	if !response.AddGalleryImages {
+		return fmt.Errorf("stash didn't add the images to gallery %s", galleryID)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package stash
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Job statuses reported by findJob.
+const (
+	JobReady     = "READY"
+	JobRunning   = "RUNNING"
+	JobFinished  = "FINISHED"
+	JobStopping  = "STOPPING"
+	JobCancelled = "CANCELLED"
+	JobFailed    = "FAILED"
+)
+
+type Job struct {
+	ID          string   `json:"id"`
+	Status      string   `json:"status"`
+	Description string   `json:"description"`
+	Progress    *float64 `json:"progress"`
+	Error       *string  `json:"error"`
+}
+
+// Scan starts a scan of the local paths and returns its job ID.
+func (s *Manager) Scan(ctx context.Context, paths []string) (string, error) {
+	stashPaths := make([]string, len(paths))
+	for i, path := range paths {
+		stashPaths[i] = s.PathMap.ToStash(path)
+	}
+	mutation := `
+		mutation MetadataScan($input: ScanMetadataInput!) {
+			metadataScan(input: $input)
+		}
+	`
+
+	var response struct {
+		MetadataScan string `json:"metadataScan"`
+	}
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{"paths": stashPaths},
+	}
+	if err := s.run(ctx, mutation, variables, &response); err != nil {
+		return "", err
+	}
+	return response.MetadataScan, nil
+}
+
+// Generate starts generating the covers and perceptual hashes of the scenes
+// and returns its job ID. Scenes that already have them are skipped.
+func (s *Manager) Generate(ctx context.Context, sceneIDs []string) (string, error) {
+	mutation := `
+		mutation MetadataGenerate($input: GenerateMetadataInput!) {
+			metadataGenerate(input: $input)
+		}
+	`
+
+	var response struct {
+		MetadataGenerate string `json:"metadataGenerate"`
+	}
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{
+			"covers":   true,
+			"phashes":  true,
+			"sceneIDs": sceneIDs,
+		},
+	}
+	if err := s.run(ctx, mutation, variables, &response); err != nil {
+		return "", err
+	}
+	return response.MetadataGenerate, nil
+}
+
+// FindJob returns the job with id, nil once stash has dropped it from its
+// queue.
+func (s *Manager) FindJob(ctx context.Context, id string) (*Job, error) {
+	query := `
+		query FindJob($input: FindJobInput!) {
+			findJob(input: $input) {
+				id
+				status
+				description
+				progress
+				error
+			}
+		}
+	`
+
+	var response struct {
+		FindJob *Job `json:"findJob"`
+	}
+	variables := map[string]interface{}{
+		"input": map[string]interface{}{"id": id},
+	}
+	if err := s.run(ctx, query, variables, &response); err != nil {
+		return nil, err
+	}
+	return response.FindJob, nil
+}
+
+// WaitForJob polls the job every interval until it is done, calling progress
+// with its state while it runs. A failed or cancelled job is an error.
+func (s *Manager) WaitForJob(ctx context.Context, id string, interval time.Duration, progress func(Job)) error {
+	for {
+		job, err := s.FindJob(ctx, id)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return nil
+		}
+		switch job.Status {
+		case JobFinished:
+			return nil
+		case JobFailed, JobCancelled:
+			reason := job.Status
+			if job.Error != nil && *job.Error != "" {
+				reason = *job.Error
+			}
+			return fmt.Errorf("stash job %s (%s) did not finish: %s", id, job.Description, reason)
+		}
+		if progress != nil {
+			progress(*job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// FindSceneIDsInPath returns the IDs of the scenes with a file at the local
+// path or, for a directory, under it. Siblings sharing the path as a prefix,
+// such as /data/alice2 for /data/alice, don't match.
+func (s *Manager) FindSceneIDsInPath(ctx context.Context, path string) ([]string, error) {
+	query := `
+		query FindScenes($filter: FindFilterType, $scene_filter: SceneFilterType) {
+			findScenes(filter: $filter, scene_filter: $scene_filter) {
+				scenes {
+					id
+				}
+			}
+		}
+	`
+
+	var response struct {
+		FindScenes struct {
+			Scenes []Scene `json:"scenes"`
+		} `json:"findScenes"`
+	}
+	variables := map[string]interface{}{
+		"filter": map[string]interface{}{"per_page": -1},
+		"scene_filter": map[string]interface{}{
+			"path": map[string]interface{}{"value": pathPattern(s.PathMap.ToStash(path)), "modifier": "MATCHES_REGEX"},
+		},
+	}
+	if err := s.run(ctx, query, variables, &response); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(response.FindScenes.Scenes))
+	for i, scene := range response.FindScenes.Scenes {
+		ids[i] = scene.ID
+	}
+	return ids, nil
+}
+
+// pathPattern returns a regex matching the stash path and the paths under it.
+func pathPattern(path string) string {
+	path = strings.TrimRight(path, `/\`)
+	return "^" + regexp.QuoteMeta(path) + "(" + regexp.QuoteMeta(separator(path)) + "|$)"
+}
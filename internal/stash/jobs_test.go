@@ -0,0 +1,58 @@
+package stash
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestPathPattern(t *testing.T) {
+	tests := []struct {
+		path  string
+		match []string
+		skip  []string
+	}{
+		{
+			path:  "/data/alice",
+			match: []string{"/data/alice", "/data/alice/videos/a.mp4"},
+			skip:  []string{"/data/alice2/videos/a.mp4", "/other/data/alice/a.mp4", "/data/alic"},
+		},
+		{
+			path:  "/data/alice/",
+			match: []string{"/data/alice/videos/a.mp4"},
+			skip:  []string{"/data/alice2"},
+		},
+		{
+			path:  "/data/alice/videos/a.mp4",
+			match: []string{"/data/alice/videos/a.mp4"},
+			skip:  []string{"/data/alice/videos/a.mp4.part"},
+		},
+		{
+			path:  "/",
+			match: []string{"/", "/alice/videos/a.mp4"},
+			skip:  []string{`D:\alice\a.mp4`},
+		},
+		{
+			path:  `D:\library\alice`,
+			match: []string{`D:\library\alice\videos\a.mp4`},
+			skip:  []string{`D:\library\alice2\videos\a.mp4`},
+		},
+		{
+			path:  "/data/a+b (1)",
+			match: []string{"/data/a+b (1)/a.mp4"},
+			skip:  []string{"/data/aab 1/a.mp4"},
+		},
+	}
+	for _, tt := range tests {
+		pattern := regexp.MustCompile(pathPattern(tt.path))
+		for _, path := range tt.match {
+			if !pattern.MatchString(path) {
+				t.Errorf("pattern of %s doesn't match %s", tt.path, path)
+			}
+		}
+		for _, path := range tt.skip {
+			if pattern.MatchString(path) {
+				t.Errorf("pattern of %s matches %s", tt.path, path)
+			}
+		}
+	}
+}
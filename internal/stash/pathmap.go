@@ -0,0 +1,81 @@
+package stash
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PathRule maps a directory as party-dl sees it to the same directory as
+// stash sees it, e.g. when stash runs in a container.
+type PathRule struct {
+	Local string
+	Stash string
+}
+
+// PathMap translates local paths to stash paths. The rule with the longest
+// matching local directory wins, paths no rule matches are used as they are.
+type PathMap []PathRule
+
+// ParsePathMap parses rules of the form /local/dir=/stash/dir.
+func ParsePathMap(rules []string) (PathMap, error) {
+	var pathMap PathMap
+	for _, rule := range rules {
+		local, stashPath, ok := strings.Cut(rule, "=")
+		if !ok || local == "" || stashPath == "" {
+			return nil, fmt.Errorf("invalid path map %q, expected LOCAL=STASH", rule)
+		}
+		local, err := filepath.Abs(local)
+		if err != nil {
+			return nil, err
+		}
+		pathMap = append(pathMap, PathRule{Local: local, Stash: trimSeparators(stashPath)})
+	}
+	sort.SliceStable(pathMap, func(i, j int) bool {
+		return len(pathMap[i].Local) > len(pathMap[j].Local)
+	})
+	return pathMap, nil
+}
+
+// ToStash returns the stash path of the local path.
+func (m PathMap) ToStash(path string) string {
+	path = filepath.Clean(path)
+	for _, rule := range m {
+		if path == rule.Local {
+			return rule.Stash
+		}
+		// Only a root such as / ends in a separator.
+		prefix := rule.Local
+		if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+			prefix += string(filepath.Separator)
+		}
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			sep := separator(rule.Stash)
+			stashDir := rule.Stash
+			if !strings.HasSuffix(stashDir, sep) {
+				stashDir += sep
+			}
+			return stashDir + strings.ReplaceAll(rest, string(filepath.Separator), sep)
+		}
+	}
+	return path
+}
+
+// trimSeparators removes the trailing separators of a stash path, except the
+// one of a root such as / or D:\.
+func trimSeparators(stashPath string) string {
+	trimmed := strings.TrimRight(stashPath, `/\`)
+	if (trimmed == "" || strings.HasSuffix(trimmed, ":")) && trimmed != stashPath {
+		return stashPath[:len(trimmed)+1]
+	}
+	return trimmed
+}
+
+// separator guesses the path separator of stash from one of its paths.
+func separator(stashPath string) string {
+	if strings.Contains(stashPath, `\`) && !strings.Contains(stashPath, "/") {
+		return `\`
+	}
+	return "/"
+}
@@ -0,0 +1,128 @@
+package stash
+
+import (
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func skipOnWindows(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the local paths of the tests are unix paths")
+	}
+}
+
+func TestParsePathMap(t *testing.T) {
+	skipOnWindows(t)
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		rules   []string
+		want    PathMap
+		wantErr bool
+	}{
+		{name: "none", want: nil},
+		{
+			name:  "longest local first",
+			rules: []string{"/mnt/nas=/data", "/mnt/nas/party-dl=/party/", `/mnt/other=D:\library\`},
+			want: PathMap{
+				{Local: "/mnt/nas/party-dl", Stash: "/party"},
+				{Local: "/mnt/other", Stash: `D:\library`},
+				{Local: "/mnt/nas", Stash: "/data"},
+			},
+		},
+		{
+			name:  "roots",
+			rules: []string{"/=/data", "/mnt/nas=/", `/mnt/win=D:\`, "/mnt/drive=D:"},
+			want: PathMap{
+				{Local: "/mnt/drive", Stash: "D:"},
+				{Local: "/mnt/nas", Stash: "/"},
+				{Local: "/mnt/win", Stash: `D:\`},
+				{Local: "/", Stash: "/data"},
+			},
+		},
+		{
+			name:  "relative local",
+			rules: []string{"library/=/data"},
+			want:  PathMap{{Local: filepath.Join(cwd, "library"), Stash: "/data"}},
+		},
+		{name: "missing separator", rules: []string{"/mnt/nas"}, wantErr: true},
+		{name: "empty local", rules: []string{"=/data"}, wantErr: true},
+		{name: "empty stash", rules: []string{"/mnt/nas="}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePathMap(tt.rules)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePathMap() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePathMap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathMapToStash(t *testing.T) {
+	skipOnWindows(t)
+	pathMap, err := ParsePathMap([]string{"/mnt/nas=/data", "/mnt/nas/party-dl=/party", `/mnt/win=D:\library`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/mnt/nas", "/data"},
+		{"/mnt/nas/", "/data"},
+		{"/mnt/nas/other/a.mp4", "/data/other/a.mp4"},
+		{"/mnt/nas/party-dl/alice/videos/a.mp4", "/party/alice/videos/a.mp4"},
+		{"/mnt/nas/party-dl2/a.mp4", "/data/party-dl2/a.mp4"},
+		{"/mnt/nas2/a.mp4", "/mnt/nas2/a.mp4"},
+		{"/mnt/win/alice/videos/a.mp4", `D:\library\alice\videos\a.mp4`},
+		{"/mnt/nas/./party-dl/../a.mp4", "/data/a.mp4"},
+		{"/home/user/a.mp4", "/home/user/a.mp4"},
+	}
+	for _, tt := range tests {
+		if got := pathMap.ToStash(tt.path); got != tt.want {
+			t.Errorf("ToStash(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	roots, err := ParsePathMap([]string{"/=/data", "/mnt/nas=/", `/mnt/win=D:\`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/data"},
+		{"/home/user/a.mp4", "/data/home/user/a.mp4"},
+		{"/mnt/nas", "/"},
+		{"/mnt/nas/alice/a.mp4", "/alice/a.mp4"},
+		{"/mnt/win", `D:\`},
+		{"/mnt/win/alice/a.mp4", `D:\alice\a.mp4`},
+	}
+	for _, tt := range rootTests {
+		if got := roots.ToStash(tt.path); got != tt.want {
+			t.Errorf("ToStash(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	var empty PathMap
+	if got := empty.ToStash("/mnt/nas/a.mp4"); got != "/mnt/nas/a.mp4" {
+		t.Errorf("empty ToStash() = %q, want the path unchanged", got)
+	}
+}
@@ -4,45 +4,133 @@ import (
 	"context"
 	"fmt"
 	"github.com/machinebox/graphql"
+	"net/http"
+	"strings"
 )
 
+// UpdateInput is the metadata set on a scene or image. URLs and TagIDs are
+// added to the ones the entry already has, so links and tags added in stash
+// by hand are kept.
 type UpdateInput struct {
-	ID          float64 `json:"id,omitempty"`
-	Title       string  `json:"title,omitempty"`
-	Date        string  `json:"date,omitempty"`
-	StudioID    string  `json:"studio_id,omitempty"`
-	Details     string  `json:"details,omitempty"`
-	URLs        string  `json:"urls,omitempty"`
-	PerformerID string  `json:"performer_ids,omitempty"`
+	ID          string
+	Title       string
+	Date        string
+	StudioID    string
+	Details     string
+	URLs        []string
+	Code        string
+	PerformerID string
+	TagIDs      []string
+}
+
+// bulkInput returns input as the input of a bulk update of its entry, which
+// unlike the single updates can add to lists instead of replacing them.
+func (input UpdateInput) bulkInput() map[string]interface{} {
+	bulk := map[string]interface{}{"ids": []string{input.ID}}
+	for key, value := range map[string]string{
+		"title":     input.Title,
+		"date":      input.Date,
+		"studio_id": input.StudioID,
+		"details":   input.Details,
+		"code":      input.Code,
+	} {
+		if value != "" {
+			bulk[key] = value
+		}
+	}
+	if input.PerformerID != "" {
+		bulk["performer_ids"] = map[string]interface{}{"ids": []string{input.PerformerID}, "mode": "SET"}
+	}
+	if len(input.URLs) > 0 {
+		bulk["urls"] = map[string]interface{}{"values": input.URLs, "mode": "ADD"}
+	}
+	if len(input.TagIDs) > 0 {
+		bulk["tag_ids"] = map[string]interface{}{"ids": input.TagIDs, "mode": "ADD"}
+	}
+	return bulk
+}
+
+// File is a file of a scene or image.
+type File struct {
+	Path     string `json:"path"`
+	Basename string `json:"basename"`
+	Size     int64  `json:"size"`
+}
+
+type Scene struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Files []File `json:"files"`
+}
+
+type Image struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Files []File `json:"files"`
+}
+
+// AmbiguousMatchError is returned when more than one scene or image has the
+// file being looked up, so it can't be told which one to update.
+type AmbiguousMatchError struct {
+	Kind     string
+	FileName string
+	IDs      []string
+}
+
+func (e *AmbiguousMatchError) Error() string {
+	return fmt.Sprintf("%s matches %d %s (%s), not updating any of them", e.FileName, len(e.IDs), e.Kind, strings.Join(e.IDs, ", "))
 }
 
 type Manager struct {
 	Client *graphql.Client
 	URL    string
+	// PathMap translates the local paths passed to the manager to the paths
+	// stash sees.
+	PathMap PathMap
 }
 
-func NewManager(url string) *Manager {
-	client := graphql.NewClient(url + "/graphql")
+// NewManager returns a manager for the stash at url. apiKey is sent with
+// every request, it can be empty if authentication is turned off.
+func NewManager(url, apiKey string) *Manager {
+	httpClient := &http.Client{
+		Transport: &authTransport{Base: http.DefaultTransport, APIKey: apiKey},
+		// Login redirects are reported by the transport.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	client := graphql.NewClient(url+"/graphql", graphql.WithHTTPClient(httpClient))
 	return &Manager{
 		Client: client,
 		URL:    url,
 	}
 }
 
-func (s *Manager) findEntity(query string, variables map[string]interface{}, findKey string, responseKey string) (string, error) {
-	response, err := s.executeQuery(query, variables, findKey)
+func (s *Manager) findEntity(ctx context.Context, query string, variables map[string]interface{}, findKey string, responseKey string) (string, error) {
+	response, err := s.executeQuery(ctx, query, variables, findKey)
 	if err != nil {
 		return "", err
 	}
 
-	count := int(response["count"].(float64))
-	if count == 0 {
+	entities, ok := response[responseKey].([]interface{})
+	if !ok {
+		return "", fmt.Errorf("stash response of %s has no %s", findKey, responseKey)
+	}
+	if len(entities) == 0 {
 		return "", nil
 	}
-	return response[responseKey].([]interface{})[0].(map[string]interface{})["id"].(string), nil
+	entity, ok := entities[0].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("stash response of %s has an invalid %s", findKey, responseKey)
+	}
+	id, ok := entity["id"].(string)
+	if !ok {
+		return "", fmt.Errorf("stash response of %s has no id", findKey)
+	}
+	return id, nil
 }
 
-func (s *Manager) GetOrCreateStudio(name, url string) (string, error) {
+func (s *Manager) GetOrCreateStudio(ctx context.Context, name, url string) (string, error) {
 	findQuery := `
 		query FindStudios($name: String!) {
 		  findStudios(filter: {q: ""}, studio_filter: {name: {value: $name, modifier: EQUALS}}) {
@@ -58,19 +146,19 @@ func (s *Manager) GetOrCreateStudio(name, url string) (string, error) {
 	variables := map[string]interface{}{
 		"name": name,
 	}
-	entityID, err := s.findEntity(findQuery, variables, "findStudios", "studios")
+	entityID, err := s.findEntity(ctx, findQuery, variables, "findStudios", "studios")
 	if err != nil {
 		return "", err
 	}
 
 	if entityID == "" {
-		return s.createEntity("studioCreate", "StudioCreateInput", map[string]string{"name": name, "url": url})
+		return s.createEntity(ctx, "studioCreate", "StudioCreateInput", map[string]string{"name": name, "url": url})
 	}
 
 	return entityID, nil
 }
 
-func (s *Manager) GetOrCreatePerformer(performerName string, url string) (string, error) {
+func (s *Manager) GetOrCreatePerformer(ctx context.Context, performerName string, url string) (string, error) {
 	findQuery := `
 		query FindPerformers($filter: FindFilterType, $performer_filter: PerformerFilterType) {
 			findPerformers(filter: $filter, performer_filter: $performer_filter) {
@@ -86,121 +174,306 @@ func (s *Manager) GetOrCreatePerformer(performerName string, url string) (string
 	variables := map[string]interface{}{
 		"filter": map[string]string{"q": performerName},
 	}
-	entityID, err := s.findEntity(findQuery, variables, "findPerformers", "performers")
+	entityID, err := s.findEntity(ctx, findQuery, variables, "findPerformers", "performers")
 	if err != nil {
 		return "", err
 	}
 
 	if entityID == "" {
-		return s.createEntity("performerCreate", "PerformerCreateInput", map[string]string{"name": performerName, "url": url})
+		return s.createEntity(ctx, "performerCreate", "PerformerCreateInput", map[string]string{"name": performerName, "url": url})
 	}
 
 	return entityID, nil
 }
 
-func (s *Manager) GetSceneByPathAndSize(path string, fileSize int64) ([]interface{}, bool, error) {
-	query := fmt.Sprintf(`
-        mutation {
-            querySQL(
-                sql: "SELECT folders.path, files.basename, files.size, files.id AS files_id, folders.id AS folders_id, scenes.id AS scenes_id, scenes.title AS scenes_title, scenes.details AS scenes_details FROM files JOIN folders ON files.parent_folder_id = folders.id JOIN scenes_files ON files.id = scenes_files.file_id JOIN scenes ON scenes.id = scenes_files.scene_id  WHERE files.basename LIKE '%%%s%%' AND files.size = %d"
-            ) {
-                rows
-            }
-        }
-    `, path, fileSize)
-
-	variables := map[string]interface{}{}
+// GetOrCreateTag returns the ID of the tag named name, or having name as an
+// alias, creating it if there is none.
+func (s *Manager) GetOrCreateTag(ctx context.Context, name string) (string, error) {
+	findQuery := `
+		query FindTags($name: String!) {
+		  findTags(filter: {per_page: 1}, tag_filter: {
+		    name: {value: $name, modifier: EQUALS}
+		    OR: {aliases: {value: $name, modifier: EQUALS}}
+		  }) {
+		    count
+		    tags {
+		      id
+		      name
+		    }
+		  }
+		}
+	`
 
-	responseData, err := s.executeQuery(query, variables, "querySQL")
+	variables := map[string]interface{}{
+		"name": name,
+	}
+	entityID, err := s.findEntity(ctx, findQuery, variables, "findTags", "tags")
 	if err != nil {
+		return "", err
+	}
+
+	if entityID == "" {
+		return s.createEntity(ctx, "tagCreate", "TagCreateInput", map[string]string{"name": name})
+	}
+
+	return entityID, nil
+}
+
+// GetSceneByPathAndSize finds the scene with the file at the local path of
+// fileSize bytes. More than one such scene is reported as an
+// *AmbiguousMatchError.
+func (s *Manager) GetSceneByPathAndSize(ctx context.Context, path string, fileSize int64) (*Scene, bool, error) {
+	path = s.PathMap.ToStash(path)
+	query := `
+		query FindScenes($filter: FindFilterType, $scene_filter: SceneFilterType) {
+			findScenes(filter: $filter, scene_filter: $scene_filter) {
+				count
+				scenes {
+					id
+					title
+					files {
+						path
+						basename
+						size
+					}
+				}
+			}
+		}
+	`
+
+	var response struct {
+		FindScenes struct {
+			Count  int     `json:"count"`
+			Scenes []Scene `json:"scenes"`
+		} `json:"findScenes"`
+	}
+	variables := map[string]interface{}{
+		"filter":       map[string]interface{}{"per_page": -1},
+		"scene_filter": pathFilter(path),
+	}
+	if err := s.run(ctx, query, variables, &response); err != nil {
 		return nil, false, err
 	}
 
-	if len(responseData["rows"].([]interface{})) == 0 {
+	var matches []Scene
+	for _, scene := range response.FindScenes.Scenes {
+		if hasFile(scene.Files, path, fileSize) {
+			matches = append(matches, scene)
+		}
+	}
+	switch len(matches) {
+	case 0:
 		return nil, false, nil
+	case 1:
+		return &matches[0], true, nil
+	default:
+		ids := make([]string, len(matches))
+		for i, scene := range matches {
+			ids[i] = scene.ID
+		}
+		return nil, false, &AmbiguousMatchError{Kind: "scenes", FileName: path, IDs: ids}
+	}
+}
+
+// GetImageByPathAndSize finds the image with the file at the local path of
+// fileSize bytes. More than one such image is reported as an
+// *AmbiguousMatchError.
+func (s *Manager) GetImageByPathAndSize(ctx context.Context, path string, fileSize int64) (*Image, bool, error) {
+	path = s.PathMap.ToStash(path)
+	query := `
+		query FindImages($filter: FindFilterType, $image_filter: ImageFilterType) {
+			findImages(filter: $filter, image_filter: $image_filter) {
+				count
+				images {
+					id
+					title
+					files {
+						path
+						basename
+						size
+					}
+				}
+			}
+		}
+	`
+
+	var response struct {
+		FindImages struct {
+			Count  int     `json:"count"`
+			Images []Image `json:"images"`
+		} `json:"findImages"`
+	}
+	variables := map[string]interface{}{
+		"filter":       map[string]interface{}{"per_page": -1},
+		"image_filter": pathFilter(path),
+	}
+	if err := s.run(ctx, query, variables, &response); err != nil {
+		return nil, false, err
 	}
 
-	return responseData["rows"].([]interface{})[0].([]interface{}), true, nil
+	var matches []Image
+	for _, image := range response.FindImages.Images {
+		if hasFile(image.Files, path, fileSize) {
+			matches = append(matches, image)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, false, nil
+	case 1:
+		return &matches[0], true, nil
+	default:
+		ids := make([]string, len(matches))
+		for i, image := range matches {
+			ids[i] = image.ID
+		}
+		return nil, false, &AmbiguousMatchError{Kind: "images", FileName: path, IDs: ids}
+	}
 }
 
-func (s *Manager) GetImageByPathAndSize(path string, fileSize int64) ([]interface{}, bool, error) {
-	query := fmt.Sprintf(`
-		mutation {
-			querySQL(
-				sql: "SELECT folders.path, files.basename, files.size, files.id AS files_id, folders.id AS folders_id, images.id AS images_id, images.title AS images_title FROM files JOIN folders ON files.parent_folder_id=folders.id JOIN images_files ON files.id = images_files.file_id JOIN images ON images.id = images_files.image_id WHERE files.basename LIKE '%%%s%%' AND files.size = %d"
-			) {
-				rows
+// FindSceneByHash finds the scene with a file of the given oshash or MD5,
+// either can be empty.
+func (s *Manager) FindSceneByHash(ctx context.Context, oshash, checksum string) (*Scene, bool, error) {
+	query := `
+		query FindSceneByHash($input: SceneHashInput!) {
+			findSceneByHash(input: $input) {
+				id
+				title
+				files {
+					path
+					basename
+					size
+				}
 			}
 		}
-	`, path, fileSize)
+	`
 
-	variables := map[string]interface{}{}
+	var response struct {
+		FindSceneByHash *Scene `json:"findSceneByHash"`
+	}
+	input := map[string]interface{}{}
+	if oshash != "" {
+		input["oshash"] = oshash
+	}
+	if checksum != "" {
+		input["checksum"] = checksum
+	}
+	if len(input) == 0 {
+		return nil, false, nil
+	}
+	if err := s.run(ctx, query, map[string]interface{}{"input": input}, &response); err != nil {
+		return nil, false, err
+	}
+	if response.FindSceneByHash == nil {
+		return nil, false, nil
+	}
+	return response.FindSceneByHash, true, nil
+}
 
-	responseData, err := s.executeQuery(query, variables, "querySQL")
-	if err != nil {
+// FindImageByChecksum finds the image with a file of the given MD5. More
+// than one such image is reported as an *AmbiguousMatchError.
+func (s *Manager) FindImageByChecksum(ctx context.Context, checksum string) (*Image, bool, error) {
+	if checksum == "" {
+		return nil, false, nil
+	}
+	query := `
+		query FindImages($filter: FindFilterType, $image_filter: ImageFilterType) {
+			findImages(filter: $filter, image_filter: $image_filter) {
+				count
+				images {
+					id
+					title
+					files {
+						path
+						basename
+						size
+					}
+				}
+			}
+		}
+	`
+
+	var response struct {
+		FindImages struct {
+			Count  int     `json:"count"`
+			Images []Image `json:"images"`
+		} `json:"findImages"`
+	}
+	variables := map[string]interface{}{
+		"filter": map[string]interface{}{"per_page": -1},
+		"image_filter": map[string]interface{}{
+			"checksum": map[string]interface{}{"value": checksum, "modifier": "EQUALS"},
+		},
+	}
+	if err := s.run(ctx, query, variables, &response); err != nil {
 		return nil, false, err
 	}
 
-	if len(responseData["rows"].([]interface{})) == 0 {
+	images := response.FindImages.Images
+	switch len(images) {
+	case 0:
 		return nil, false, nil
+	case 1:
+		return &images[0], true, nil
+	default:
+		ids := make([]string, len(images))
+		for i, image := range images {
+			ids[i] = image.ID
+		}
+		return nil, false, &AmbiguousMatchError{Kind: "images", FileName: checksum, IDs: ids}
+	}
+}
+
+// pathFilter matches scenes or images with a file at the stash path.
+func pathFilter(path string) map[string]interface{} {
+	return map[string]interface{}{
+		"path":       map[string]interface{}{"value": path, "modifier": "EQUALS"},
+		"file_count": map[string]interface{}{"value": 0, "modifier": "GREATER_THAN"},
 	}
+}
 
-	return responseData["rows"].([]interface{})[0].([]interface{}), true, nil
+func hasFile(files []File, path string, fileSize int64) bool {
+	for _, file := range files {
+		if file.Path == path && file.Size == fileSize {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *Manager) UpdateScene(sceneUpdateInput UpdateInput) (map[string]interface{}, error) {
+func (s *Manager) UpdateScene(ctx context.Context, sceneUpdateInput UpdateInput) (map[string]interface{}, error) {
 	mutation := `
-        mutation sceneUpdate($sceneUpdateInput: SceneUpdateInput!){
-            sceneUpdate(input: $sceneUpdateInput){
+        mutation bulkSceneUpdate($input: BulkSceneUpdateInput!){
+            bulkSceneUpdate(input: $input){
                 id
-                title
-                date
-                studio {
-                    id
-                }
-				performers {
-					id
-				}
-                details
-                urls
             }
         }
     `
 
 	variables := map[string]interface{}{
-		"sceneUpdateInput": sceneUpdateInput,
+		"input": sceneUpdateInput.bulkInput(),
 	}
 
-	return s.executeMutation(mutation, variables)
+	return s.executeMutation(ctx, mutation, variables)
 }
 
-func (s *Manager) UpdateImage(imageUpdateInput UpdateInput) (map[string]interface{}, error) {
+func (s *Manager) UpdateImage(ctx context.Context, imageUpdateInput UpdateInput) (map[string]interface{}, error) {
 	mutation := `
-        mutation imageUpdate($imageUpdateInput: ImageUpdateInput!){
-            imageUpdate(input: $imageUpdateInput){
+        mutation bulkImageUpdate($input: BulkImageUpdateInput!){
+            bulkImageUpdate(input: $input){
                 id
-                title
-                date
-                studio {
-                    id
-                }
-				performers {
-					id
-				}
-                details
-                urls
             }
         }
     `
 
 	variables := map[string]interface{}{
-		"imageUpdateInput": imageUpdateInput,
+		"input": imageUpdateInput.bulkInput(),
 	}
 
-	return s.executeMutation(mutation, variables)
+	return s.executeMutation(ctx, mutation, variables)
 }
 
-func (s *Manager) createEntity(mutationName, mutationType string, input map[string]string) (string, error) {
+func (s *Manager) createEntity(ctx context.Context, mutationName, mutationType string, input interface{}) (string, error) {
 	mutation := fmt.Sprintf(`
 		mutation ($input: %s!) {
 			%s(input: $input) {
@@ -212,38 +485,55 @@ func (s *Manager) createEntity(mutationName, mutationType string, input map[stri
 		"input": input,
 	}
 
-	response, err := s.executeMutation(mutation, mutationVariables)
+	response, err := s.executeMutation(ctx, mutation, mutationVariables)
 	if err != nil {
 		return "", err
 	}
 
-	return response[mutationName].(map[string]interface{})["id"].(string), nil
+	created, ok := response[mutationName].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("stash response has no %s", mutationName)
+	}
+	id, ok := created["id"].(string)
+	if !ok {
+		return "", fmt.Errorf("stash response of %s has no id", mutationName)
+	}
+	return id, nil
+}
+
+// run executes query and decodes its data into response.
+func (s *Manager) run(ctx context.Context, query string, variables map[string]interface{}, response interface{}) error {
+	req := graphql.NewRequest(query)
+	for key, value := range variables {
+		req.Var(key, value)
+	}
+	return s.Client.Run(ctx, req, response)
 }
 
-func (s *Manager) executeMutation(query string, variables map[string]interface{}) (map[string]interface{}, error) {
+func (s *Manager) executeMutation(ctx context.Context, query string, variables map[string]interface{}) (map[string]interface{}, error) {
 	req := graphql.NewRequest(query)
 	for key, value := range variables {
 		req.Var(key, value)
 	}
 	var response map[string]interface{}
-	if err := s.Client.Run(context.Background(), req, &response); err != nil {
+	if err := s.Client.Run(ctx, req, &response); err != nil {
 		return nil, err
 	}
 	return response, nil
 }
 
-func (s *Manager) executeQuery(query string, variables map[string]interface{}, responseKey string) (map[string]interface{}, error) {
+func (s *Manager) executeQuery(ctx context.Context, query string, variables map[string]interface{}, responseKey string) (map[string]interface{}, error) {
 	req := graphql.NewRequest(query)
 	for key, value := range variables {
 		req.Var(key, value)
 	}
 	var response map[string]interface{}
-	if err := s.Client.Run(context.Background(), req, &response); err != nil {
+	if err := s.Client.Run(ctx, req, &response); err != nil {
 		return nil, err
 	}
 	responseData, ok := response[responseKey].(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("failed to extract data from response")
+		return nil, fmt.Errorf("stash response has no %s", responseKey)
 	}
 	return responseData, nil
 }
@@ -0,0 +1,244 @@
+package stash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// graphqlRequest is a request received by the GraphQL stub.
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// graphqlStub answers every GraphQL request with data and records the
+// requests it received.
+func graphqlStub(t *testing.T, data string) (*Manager, *[]graphqlRequest) {
+	t.Helper()
+	var requests []graphqlRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/graphql" {
+			http.NotFound(w, r)
+			return
+		}
+		var req graphqlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid GraphQL request: %v", err)
+		}
+		requests = append(requests, req)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": ` + data + `}`))
+	}))
+	t.Cleanup(server.Close)
+	return NewManager(server.URL, ""), &requests
+}
+
+// filesJSON returns entries with the given IDs and files as JSON.
+func filesJSON(t *testing.T, entries map[string][]File) string {
+	t.Helper()
+	list := []map[string]interface{}{}
+	for _, id := range []string{"1", "2", "3"} {
+		if files, ok := entries[id]; ok {
+			list = append(list, map[string]interface{}{"id": id, "title": "", "files": files})
+		}
+	}
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(encoded)
+}
+
+// pathLookupTests are the results stash returns for a lookup of
+// /data/alice/videos/a.mp4 of 100 bytes.
+var pathLookupTests = []struct {
+	name    string
+	entries map[string][]File
+	wantID  string
+	wantIDs []string
+}{
+	{name: "no match"},
+	{
+		name:    "one match",
+		entries: map[string][]File{"1": {{Path: "/data/alice/videos/a.mp4", Size: 100}}},
+		wantID:  "1",
+	},
+	{
+		name: "one of several files",
+		entries: map[string][]File{"1": {
+			{Path: "/data/other/a.mp4", Size: 100},
+			{Path: "/data/alice/videos/a.mp4", Size: 100},
+		}},
+		wantID: "1",
+	},
+	{
+		name:    "same path, different size",
+		entries: map[string][]File{"1": {{Path: "/data/alice/videos/a.mp4", Size: 99}}},
+	},
+	{
+		name:    "different path",
+		entries: map[string][]File{"1": {{Path: "/data/alice/videos/a.mp4.bak", Size: 100}}},
+	},
+	{
+		name: "multiple matches",
+		entries: map[string][]File{
+			"1": {{Path: "/data/alice/videos/a.mp4", Size: 100}},
+			"2": {{Path: "/data/alice/videos/a.mp4", Size: 99}},
+			"3": {{Path: "/data/alice/videos/a.mp4", Size: 100}},
+		},
+		wantIDs: []string{"1", "3"},
+	},
+}
+
+// checkPathLookup checks the result of a lookup of pathLookupTests and the
+// variables sent to stash.
+func checkPathLookup(t *testing.T, id string, found bool, err error, wantID string, wantIDs []string, requests []graphqlRequest, filterKey string) {
+	t.Helper()
+	if wantIDs != nil {
+		var ambiguous *AmbiguousMatchError
+		if !errors.As(err, &ambiguous) {
+			t.Fatalf("got %v, want an *AmbiguousMatchError", err)
+		}
+		if !reflect.DeepEqual(ambiguous.IDs, wantIDs) {
+			t.Errorf("ambiguous IDs = %v, want %v", ambiguous.IDs, wantIDs)
+		}
+	} else if err != nil {
+		t.Fatal(err)
+	} else if found != (wantID != "") || id != wantID {
+		t.Errorf("got %q, %v, want %q", id, found, wantID)
+	}
+
+	if len(requests) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(requests))
+	}
+	want := map[string]interface{}{
+		"filter": map[string]interface{}{"per_page": float64(-1)},
+		filterKey: map[string]interface{}{
+			"path":       map[string]interface{}{"value": "/data/alice/videos/a.mp4", "modifier": "EQUALS"},
+			"file_count": map[string]interface{}{"value": float64(0), "modifier": "GREATER_THAN"},
+		},
+	}
+	if !reflect.DeepEqual(requests[0].Variables, want) {
+		t.Errorf("variables = %v, want %v", requests[0].Variables, want)
+	}
+}
+
+func TestGetSceneByPathAndSize(t *testing.T) {
+	skipOnWindows(t)
+	for _, tt := range pathLookupTests {
+		t.Run(tt.name, func(t *testing.T) {
+			stashManager, requests := graphqlStub(t, `{"findScenes": {"count": 0, "scenes": `+filesJSON(t, tt.entries)+`}}`)
+			stashManager.PathMap = PathMap{{Local: "/mnt/nas", Stash: "/data"}}
+
+			scene, found, err := stashManager.GetSceneByPathAndSize(context.Background(), "/mnt/nas/alice/videos/a.mp4", 100)
+			id := ""
+			if scene != nil {
+				id = scene.ID
+			}
+			checkPathLookup(t, id, found, err, tt.wantID, tt.wantIDs, *requests, "scene_filter")
+		})
+	}
+}
+
+func TestGetImageByPathAndSize(t *testing.T) {
+	skipOnWindows(t)
+	for _, tt := range pathLookupTests {
+		t.Run(tt.name, func(t *testing.T) {
+			stashManager, requests := graphqlStub(t, `{"findImages": {"count": 0, "images": `+filesJSON(t, tt.entries)+`}}`)
+			stashManager.PathMap = PathMap{{Local: "/mnt/nas", Stash: "/data"}}
+
+			image, found, err := stashManager.GetImageByPathAndSize(context.Background(), "/mnt/nas/alice/videos/a.mp4", 100)
+			id := ""
+			if image != nil {
+				id = image.ID
+			}
+			checkPathLookup(t, id, found, err, tt.wantID, tt.wantIDs, *requests, "image_filter")
+		})
+	}
+}
+
+func TestFindImageByChecksum(t *testing.T) {
+	file := []File{{Path: "/data/alice/images/a.jpg", Size: 100}}
+	tests := []struct {
+		name    string
+		images  map[string][]File
+		wantID  string
+		wantIDs []string
+	}{
+		{name: "no match"},
+		{name: "one match", images: map[string][]File{"2": file}, wantID: "2"},
+		{name: "multiple matches", images: map[string][]File{"1": file, "2": file}, wantIDs: []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stashManager, requests := graphqlStub(t, `{"findImages": {"count": 0, "images": `+filesJSON(t, tt.images)+`}}`)
+
+			image, found, err := stashManager.FindImageByChecksum(context.Background(), "5d41402abc4b2a76b9719d911017c592")
+			if tt.wantIDs != nil {
+				var ambiguous *AmbiguousMatchError
+				if !errors.As(err, &ambiguous) {
+					t.Fatalf("got %v, want an *AmbiguousMatchError", err)
+				}
+				if !reflect.DeepEqual(ambiguous.IDs, tt.wantIDs) {
+					t.Errorf("ambiguous IDs = %v, want %v", ambiguous.IDs, tt.wantIDs)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if found != (tt.wantID != "") || (found && image.ID != tt.wantID) {
+				t.Errorf("got %+v, %v, want %q", image, found, tt.wantID)
+			}
+
+			want := map[string]interface{}{
+				"checksum": map[string]interface{}{"value": "5d41402abc4b2a76b9719d911017c592", "modifier": "EQUALS"},
+			}
+			if len(*requests) != 1 || !reflect.DeepEqual((*requests)[0].Variables["image_filter"], want) {
+				t.Errorf("requests = %+v, want one with image_filter %v", *requests, want)
+			}
+		})
+	}
+}
+
+func TestFindByEmptyHash(t *testing.T) {
+	stashManager, requests := graphqlStub(t, `{}`)
+	if _, found, err := stashManager.FindImageByChecksum(context.Background(), ""); found || err != nil {
+		t.Errorf("FindImageByChecksum(\"\") = %v, %v, want not found", found, err)
+	}
+	if _, found, err := stashManager.FindSceneByHash(context.Background(), "", ""); found || err != nil {
+		t.Errorf("FindSceneByHash(\"\", \"\") = %v, %v, want not found", found, err)
+	}
+	if len(*requests) != 0 {
+		t.Errorf("sent %d requests for empty hashes, want none", len(*requests))
+	}
+}
+
+func TestFindSceneByHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		wantID string
+	}{
+		{name: "no match", data: `{"findSceneByHash": null}`},
+		{name: "match", data: `{"findSceneByHash": {"id": "7", "title": "", "files": []}}`, wantID: "7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stashManager, requests := graphqlStub(t, tt.data)
+			scene, found, err := stashManager.FindSceneByHash(context.Background(), "c1834506c88a4c16", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != (tt.wantID != "") || (found && scene.ID != tt.wantID) {
+				t.Errorf("got %+v, %v, want %q", scene, found, tt.wantID)
+			}
+			want := map[string]interface{}{"input": map[string]interface{}{"oshash": "c1834506c88a4c16"}}
+			if len(*requests) != 1 || !reflect.DeepEqual((*requests)[0].Variables, want) {
+				t.Errorf("requests = %+v, want one with variables %v", *requests, want)
+			}
+		})
+	}
+}
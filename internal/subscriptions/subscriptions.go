@@ -0,0 +1,63 @@
+package subscriptions
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+type Subscription struct {
+	URL     string    `json:"url"`
+	Name    string    `json:"name"`
+	Service string    `json:"service"`
+	Added   time.Time `json:"added"`
+}
+
+type List struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+// Read reads the subscription list at filePath. A missing file is an empty list.
+func Read(filePath string) (*List, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &List{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var list List
+	if err := json.NewDecoder(file).Decode(&list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func Write(filePath string, list *List) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(list)
+}
+
+// Add appends the subscription unless its URL is already in the list and
+// reports whether it was added.
+func (l *List) Add(subscription Subscription) bool {
+	for _, existing := range l.Subscriptions {
+		if existing.URL == subscription.URL {
+			return false
+		}
+	}
+	if subscription.Added.IsZero() {
+		subscription.Added = time.Now()
+	}
+	l.Subscriptions = append(l.Subscriptions, subscription)
+	return true
+}
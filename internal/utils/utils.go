@@ -1,9 +1,27 @@
 package utils
 
-import "strings"
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
 
 var (
-	supportedURLs = []string{"https://coomer.su"}
+	supportedURLs = []string{"https://coomer.su", "https://kemono.su"}
+	sizeUnits     = map[string]int64{
+		"":  1,
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+	}
+	services = map[string]string{
+		"https://onlyfans.com":    "onlyfans",
+		"https://fansly.com":      "fansly",
+		"https://www.patreon.com": "patreon",
+	}
 )
 
 func IsURlSupported(url string) bool {
@@ -16,10 +34,50 @@ func IsURlSupported(url string) bool {
 }
 
 func GetService(url string) string {
-	if strings.HasPrefix(url, "https://onlyfans.com") {
-		return "onlyfans"
-	} else if strings.HasPrefix(url, "https://fansly.com") {
-		return "fansly"
+	for prefix, service := range services {
+		if strings.HasPrefix(url, prefix) {
+			return service
+		}
 	}
 	return "unknown"
 }
+
+// GetBaseURL returns the scheme and host of rawURL, e.g. https://coomer.su.
+func GetBaseURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// GetExtension returns the lower-cased extension of the URL's path, ignoring
+// any query string such as "?f=original.jpg".
+func GetExtension(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return strings.ToLower(path.Ext(rawURL))
+	}
+	return strings.ToLower(path.Ext(u.Path))
+}
+
+// ParseSize parses a byte size such as "512K", "5M", "1.5GB" or "1MiB/s" using
+// binary units.
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(value, "/S")
+	value = strings.TrimSuffix(value, "IB")
+	value = strings.TrimSuffix(value, "B")
+
+	unit := ""
+	if value != "" && strings.ContainsAny(value[len(value)-1:], "KMGT") {
+		unit = value[len(value)-1:]
+		value = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(number * float64(sizeUnits[unit])), nil
+}
@@ -0,0 +1,40 @@
+package utils
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "512", want: 512},
+		{in: "512B", want: 512},
+		{in: "512K", want: 512 << 10},
+		{in: "5M", want: 5 << 20},
+		{in: "5m", want: 5 << 20},
+		{in: "1.5GB", want: 3 << 29},
+		{in: "1MiB/s", want: 1 << 20},
+		{in: " 2T ", want: 2 << 40},
+		{in: "", wantErr: true},
+		{in: "M", wantErr: true},
+		{in: "-1K", wantErr: true},
+		{in: "5X", wantErr: true},
+		{in: "five", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSize(%q): %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
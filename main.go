@@ -1,12 +1,12 @@
 package main
 
 import (
-	"log"
+	"os"
 	"party-dl/cmd"
 )
 
 func main() {
 	if err := cmd.Execute(); err != nil {
-		log.Fatal(err)
+		os.Exit(cmd.ExitCode(err))
 	}
 }